@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Attempts per audio file when the server keeps answering 429 or 5xx
+const maxAudioAttempts = 3
+
+// Delay before the first retry of a throttled download when AdaptiveConcurrency is off,
+// doubled for each further attempt. The adaptive limiter slows down on its own instead.
+var audioRetryBackoff = 500 * time.Millisecond
+
+// Downloads the cached pronunciation audio of the given words into dir.
+// Files already present in dir are reused rather than downloaded again. Throttled
+// downloads are queued again, after audioRetryBackoff unless the adaptive limiter is on,
+// up to maxAudioAttempts times; other failures are logged and skipped. Returns a map of lowercase word to local file path.
+func (c *classifier) downloadAudioFiles(words []string, dir string, logger *slog.Logger) map[string]string {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		logger.Error("failed to create audio directory", "dir", dir, "error", err)
+		return map[string]string{}
+	}
+
+	type audioJob struct {
+		Word     string
+		URL      string
+		Attempts int
+	}
+
+	// Collect one job per audio URL, skipping words without audio
+	var jobs []audioJob
+	seenURLs := make(map[string]bool)
+	for _, word := range words {
+		word = wordKey(word)
+		cachedData, exists := c.Cache[word]
+		if !exists || cachedData.Audio == "" || seenURLs[cachedData.Audio] {
+			continue
+		}
+		seenURLs[cachedData.Audio] = true
+		jobs = append(jobs, audioJob{Word: word, URL: cachedData.Audio})
+	}
+
+	workers := c.Config.AudioDownloadWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	// With adaptive concurrency the worker count is only the ceiling; the limiter starts
+	// at one download in flight and adjusts to how the server responds
+	var limiter *adaptiveLimiter
+	if c.Config.AdaptiveConcurrency {
+		limiter = newAdaptiveLimiter(1, workers)
+	}
+
+	client := createHTTPClient(c.QueryConfig, c.ProxyConfig)
+	localFiles := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	// Each job holds at most one slot at a time, so workers can queue a job again without blocking
+	jobChan := make(chan audioJob, len(jobs))
+	var pending sync.WaitGroup
+	pending.Add(len(jobs))
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobChan {
+				if limiter != nil {
+					limiter.Acquire()
+				}
+				localPath, err := downloadAudioFile(client, job.URL, dir)
+				if limiter != nil {
+					limiter.Release(err)
+				}
+				job.Attempts++
+				if isThrottled(err) && job.Attempts < maxAudioAttempts {
+					logger.Info("retrying throttled audio", "word", job.Word, "url", job.URL, "attempt", job.Attempts, "error", err)
+					if limiter != nil {
+						jobChan <- job
+					} else {
+						// Queue the job again after the backoff without holding up this worker
+						time.AfterFunc(audioRetryBackoff<<(job.Attempts-1), func() { jobChan <- job })
+					}
+					continue
+				}
+				if err != nil {
+					logger.Warn("skipping audio", "word", job.Word, "url", job.URL, "error", err)
+				} else {
+					mu.Lock()
+					localFiles[job.Word] = localPath
+					mu.Unlock()
+				}
+				pending.Done()
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		jobChan <- job
+	}
+	pending.Wait()
+	close(jobChan)
+	wg.Wait()
+
+	if limiter != nil {
+		logger.Info("audio downloads finished", "finalConcurrency", limiter.Limit())
+	}
+	return localFiles
+}
+
+// Downloads a single audio file into dir unless it already exists there
+func downloadAudioFile(client *http.Client, audioURL string, dir string) (string, error) {
+	parsed, err := url.Parse(audioURL)
+	if err != nil {
+		return "", err
+	}
+	fileName := path.Base(parsed.Path)
+	if fileName == "" || fileName == "." || fileName == "/" {
+		return "", fmt.Errorf("no file name in audio URL %q", audioURL)
+	}
+
+	localPath := filepath.Join(dir, fileName)
+	if _, err := os.Stat(localPath); err == nil {
+		return localPath, nil
+	}
+
+	resp, err := client.Get(audioURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	// Write to a temporary file first so an interrupted download is never reused
+	tmpPath := localPath + ".part"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return "", err
+	}
+	return localPath, nil
+}
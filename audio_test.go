@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadAudioOnceAndReuse(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("audio of " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	dictionary := loadTestDictionary(t)
+	for _, word := range []string{"fox", "dog"} {
+		entry := dictionary[word]
+		entry.Audio = server.URL + "/" + word + ".mp3"
+		dictionary[word] = entry
+	}
+
+	cfg := testConfig()
+	cfg.DownloadAudio = true
+	cfg.GenerateCloze = true
+	outputDir := t.TempDir()
+	for run := 1; run <= 2; run++ {
+		deps := testDependencies(t, cfg)
+		deps.Provider = mapDictionaryProvider(dictionary)
+		deps.OutputDir = outputDir
+		if err := categorizeText(filepath.Join("testdata", "sample.txt"), deps); err != nil {
+			t.Fatal(err)
+		}
+		if got := requests.Load(); got != 2 {
+			t.Errorf("after run %d: %d audio requests, want 2", run, got)
+		}
+	}
+
+	for _, word := range []string{"fox", "dog"} {
+		data, err := os.ReadFile(filepath.Join(outputDir, "audio", word+".mp3"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "audio of /" + word + ".mp3"; string(data) != want {
+			t.Errorf("%s.mp3 = %q, want %q", word, data, want)
+		}
+	}
+
+	cloze, err := os.ReadFile(filepath.Join(outputDir, "cloze.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(cloze), "\n"), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			t.Fatalf("cloze row %q has %d fields, want 3", line, len(fields))
+		}
+		if strings.HasPrefix(fields[1], "Fox ") && fields[2] != "[sound:fox.mp3]" {
+			t.Errorf("fox audio field = %q, want [sound:fox.mp3]", fields[2])
+		}
+		if strings.HasPrefix(fields[1], "Field ") && fields[2] != "" {
+			t.Errorf("field audio field = %q, want it empty", fields[2])
+		}
+	}
+	if !strings.Contains(string(cloze), "[sound:dog.mp3]") {
+		t.Errorf("cloze.tsv has no dog audio:\n%s", cloze)
+	}
+}
+
+func TestDownloadAudioRetriesThrottled(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		count := requests[r.URL.Path]
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/flaky.mp3" && count == 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == "/down.mp3":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/missing.mp3":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte("audio"))
+		}
+	}))
+	defer server.Close()
+
+	cfg := testConfig()
+	cfg.AdaptiveConcurrency = true
+	c := newClassifier(testDependencies(t, cfg))
+	for _, word := range []string{"flaky", "down", "missing"} {
+		c.Cache[word] = WordCache{Audio: server.URL + "/" + word + ".mp3"}
+	}
+
+	files := c.downloadAudioFiles([]string{"flaky", "down", "missing"}, t.TempDir(), c.Logger)
+
+	if _, ok := files["flaky"]; !ok {
+		t.Error("throttled download not retried")
+	}
+	if _, ok := files["down"]; ok {
+		t.Error("download that always fails reported as downloaded")
+	}
+	wantRequests := map[string]int{"/flaky.mp3": 2, "/down.mp3": maxAudioAttempts, "/missing.mp3": 1}
+	for path, want := range wantRequests {
+		if got := requests[path]; got != want {
+			t.Errorf("%s requested %d times, want %d", path, got, want)
+		}
+	}
+}
+
+func TestDownloadAudioBacksOffBeforeRetryWithoutAdaptiveConcurrency(t *testing.T) {
+	original := audioRetryBackoff
+	audioRetryBackoff = 50 * time.Millisecond
+	t.Cleanup(func() { audioRetryBackoff = original })
+
+	var mu sync.Mutex
+	var requestTimes []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requestTimes = append(requestTimes, time.Now())
+		mu.Unlock()
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	c := newClassifier(testDependencies(t, testConfig()))
+	c.Cache["busy"] = WordCache{Audio: server.URL + "/busy.mp3"}
+	c.downloadAudioFiles([]string{"busy"}, t.TempDir(), c.Logger)
+
+	if len(requestTimes) != maxAudioAttempts {
+		t.Fatalf("%d requests, want %d", len(requestTimes), maxAudioAttempts)
+	}
+	for i := 1; i < len(requestTimes); i++ {
+		want := audioRetryBackoff << (i - 1)
+		if gap := requestTimes[i].Sub(requestTimes[i-1]); gap < want {
+			t.Errorf("retry %d came %v after the previous attempt, want at least %v", i, gap, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Returned for HTTP responses with an unexpected status code
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status " + e.Status
+}
+
+// Reports whether err is a response asking the client to slow down (429 or 5xx)
+func isThrottled(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+}
+
+// Limits the number of requests in flight with additive-increase/multiplicative-decrease:
+// the window grows by about one slot per window's worth of healthy responses and halves
+// on each throttled one, staying between min and max.
+type adaptiveLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	window   float64
+	min      float64
+	max      float64
+	inFlight int
+}
+
+// Starts at min concurrency, the conservative end
+func newAdaptiveLimiter(min int, max int) *adaptiveLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	l := &adaptiveLimiter{window: float64(min), min: float64(min), max: float64(max)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// Current number of requests allowed in flight
+func (l *adaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.window)
+}
+
+// Blocks until a request may start
+func (l *adaptiveLimiter) Acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= int(l.window) {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+// Finishes a request started with Acquire and adjusts the window from its outcome.
+// Errors other than throttling leave the window unchanged.
+func (l *adaptiveLimiter) Release(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.record(err)
+	l.cond.Broadcast()
+}
+
+func (l *adaptiveLimiter) record(err error) {
+	switch {
+	case err == nil:
+		l.window += 1 / l.window
+		if l.window > l.max {
+			l.window = l.max
+		}
+	case isThrottled(err):
+		l.window /= 2
+		if l.window < l.min {
+			l.window = l.min
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAdaptiveLimiterSimulatedResponses(t *testing.T) {
+	throttled := &statusError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}
+	unavailable := &statusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+	notFound := &statusError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+
+	// Window after each response, starting from 1: successes add 1/window, throttling halves it
+	steps := []struct {
+		err       error
+		wantLimit int
+	}{
+		{err: nil, wantLimit: 2},                            // 2
+		{err: nil, wantLimit: 2},                            // 2.5
+		{err: nil, wantLimit: 2},                            // 2.9
+		{err: nil, wantLimit: 3},                            // 3.24
+		{err: throttled, wantLimit: 1},                      // 1.62
+		{err: notFound, wantLimit: 1},                       // Unchanged: not a throttling response
+		{err: errors.New("connection reset"), wantLimit: 1}, // Network errors too
+		{err: nil, wantLimit: 2},                            // 2.24
+		{err: unavailable, wantLimit: 1},                    // 1.12
+		{err: unavailable, wantLimit: 1},                    // Held at the minimum
+	}
+
+	limiter := newAdaptiveLimiter(1, 4)
+	if got := limiter.Limit(); got != 1 {
+		t.Fatalf("initial limit = %d, want 1", got)
+	}
+	for i, step := range steps {
+		limiter.Acquire()
+		limiter.Release(step.err)
+		if got := limiter.Limit(); got != step.wantLimit {
+			t.Fatalf("step %d (%v): limit = %d, want %d", i+1, step.err, got, step.wantLimit)
+		}
+	}
+
+	// A long healthy run stops at the maximum
+	for i := 0; i < 50; i++ {
+		limiter.Acquire()
+		limiter.Release(nil)
+	}
+	if got := limiter.Limit(); got != 4 {
+		t.Errorf("limit after healthy run = %d, want the maximum 4", got)
+	}
+}
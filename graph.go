@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type graphEdge struct {
+	From     string
+	To       string
+	Relation string // "synonym" or "antonym"
+}
+
+// Collects the synonym and antonym relations between known words. Only relations whose
+// endpoints are both known words are kept, and each undirected pair appears once.
+func (c *classifier) buildRelationGraph(knownWords []string) ([]string, []graphEdge) {
+	known := make(map[string]bool)
+	for _, word := range knownWords {
+		known[wordKey(word)] = true
+	}
+
+	seen := make(map[string]bool)
+	nodeSet := make(map[string]bool)
+	var edges []graphEdge
+	addEdge := func(from, to, relation string) {
+		to = wordKey(to)
+		if from == to || !known[to] {
+			return
+		}
+		a, b := from, to
+		if b < a {
+			a, b = b, a
+		}
+		key := a + "\x00" + b + "\x00" + relation
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		nodeSet[a] = true
+		nodeSet[b] = true
+		edges = append(edges, graphEdge{From: a, To: b, Relation: relation})
+	}
+
+	for word := range known {
+		cachedData := c.Cache[word]
+		for _, syn := range cachedData.Synonyms {
+			addEdge(word, syn, "synonym")
+		}
+		for _, ant := range cachedData.Antonyms {
+			addEdge(word, ant, "antonym")
+		}
+	}
+
+	var nodes []string
+	for node := range nodeSet {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].To != edges[j].To {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].Relation < edges[j].Relation
+	})
+	return nodes, edges
+}
+
+// Serializes the graph as Graphviz DOT; synonyms are solid green edges, antonyms dashed red
+func formatGraphDOT(nodes []string, edges []graphEdge) string {
+	var output strings.Builder
+	output.WriteString("graph words {\n")
+	for _, node := range nodes {
+		output.WriteString(fmt.Sprintf("\t%q;\n", capitalizePhrase(node)))
+	}
+	for _, edge := range edges {
+		style := `color="darkgreen"`
+		if edge.Relation == "antonym" {
+			style = `color="red", style="dashed"`
+		}
+		output.WriteString(fmt.Sprintf("\t%q -- %q [label=%q, %s];\n",
+			capitalizePhrase(edge.From), capitalizePhrase(edge.To), edge.Relation, style))
+	}
+	output.WriteString("}\n")
+	return output.String()
+}
+
+// Serializes the graph as GraphML with a "relation" attribute on each edge
+func formatGraphML(nodes []string, edges []graphEdge) string {
+	escape := func(text string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(text))
+		return b.String()
+	}
+
+	var output strings.Builder
+	output.WriteString(xml.Header)
+	output.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	output.WriteString(`  <key id="relation" for="edge" attr.name="relation" attr.type="string"/>` + "\n")
+	output.WriteString(`  <graph id="words" edgedefault="undirected">` + "\n")
+	for _, node := range nodes {
+		output.WriteString(fmt.Sprintf("    <node id=\"%s\"/>\n", escape(capitalizePhrase(node))))
+	}
+	for _, edge := range edges {
+		output.WriteString(fmt.Sprintf("    <edge source=\"%s\" target=\"%s\"><data key=\"relation\">%s</data></edge>\n",
+			escape(capitalizePhrase(edge.From)), escape(capitalizePhrase(edge.To)), edge.Relation))
+	}
+	output.WriteString("  </graph>\n</graphml>\n")
+	return output.String()
+}
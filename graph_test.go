@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestBuildRelationGraph(t *testing.T) {
+	c := newClassifier(Dependencies{Cache: map[string]WordCache{
+		"happy": {Synonyms: []string{"glad", "joyful"}, Antonyms: []string{"sad"}},
+		"glad":  {Synonyms: []string{"Happy"}},
+		"sad":   {Antonyms: []string{"happy"}},
+		"fox":   {Synonyms: []string{"vixen"}},
+	}})
+
+	nodes, edges := c.buildRelationGraph([]string{"Happy", "glad", "sad", "fox"})
+	if want := []string{"glad", "happy", "sad"}; !slices.Equal(nodes, want) {
+		t.Errorf("nodes = %q, want %q", nodes, want)
+	}
+	wantEdges := []graphEdge{
+		{From: "glad", To: "happy", Relation: "synonym"},
+		{From: "happy", To: "sad", Relation: "antonym"},
+	}
+	if !reflect.DeepEqual(edges, wantEdges) {
+		t.Errorf("edges = %v, want %v", edges, wantEdges)
+	}
+
+	want := "graph words {\n" +
+		"\t\"Glad\";\n" +
+		"\t\"Happy\";\n" +
+		"\t\"Sad\";\n" +
+		"\t\"Glad\" -- \"Happy\" [label=\"synonym\", color=\"darkgreen\"];\n" +
+		"\t\"Happy\" -- \"Sad\" [label=\"antonym\", color=\"red\", style=\"dashed\"];\n" +
+		"}\n"
+	if got := formatGraphDOT(nodes, edges); got != want {
+		t.Errorf("formatGraphDOT =\n%s\nwant:\n%s", got, want)
+	}
+}
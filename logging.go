@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+)
+
+// Fans each record out to several handlers, so one logger can write both the
+// human-readable log and the JSON log
+type multiHandler struct {
+	handlers []slog.Handler
+}
+
+func (m *multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m.handlers {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *multiHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, h := range m.handlers {
+		if h.Enabled(ctx, record.Level) {
+			errs = append(errs, h.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(m.handlers))
+	for i, h := range m.handlers {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return &multiHandler{handlers: handlers}
+}
+
+func (m *multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, len(m.handlers))
+	for i, h := range m.handlers {
+		handlers[i] = h.WithGroup(name)
+	}
+	return &multiHandler{handlers: handlers}
+}
+
+// Creates the application logger writing to log.txt and, if jsonLogFile is set, to a
+// JSON lines file as well. The returned function closes the log files.
+func setupLogging(jsonLogFile string) (*slog.Logger, func()) {
+	var handlers []slog.Handler
+	var files []*os.File
+
+	if logFile, err := os.OpenFile("log.txt", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666); err == nil {
+		handlers = append(handlers, slog.NewTextHandler(logFile, nil))
+		files = append(files, logFile)
+	}
+
+	if jsonLogFile != "" {
+		if jsonFile, err := os.OpenFile(jsonLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666); err == nil {
+			handlers = append(handlers, slog.NewJSONHandler(jsonFile, nil))
+			files = append(files, jsonFile)
+		}
+	}
+
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	return slog.New(&multiHandler{handlers: handlers}), closeFiles
+}
@@ -2,17 +2,25 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -27,18 +35,73 @@ type InputConfig struct {
 }
 
 type OutputConfig struct {
-	IncludePhonetic          bool `yaml:"includePhonetic"`
-	IncludeOrigin            bool `yaml:"includeOrigin"`
-	IncludeSynonyms          bool `yaml:"includeSynonyms"`
-	IncludeAntonyms          bool `yaml:"includeAntonyms"`
-	FilterNoExample          bool `yaml:"filterDefinitionsWithoutExamples"`
-	GenerateExplanations     bool `yaml:"generateExplanations"`     // Toggle for explanation files
-	GenerateExampleSentences bool `yaml:"generateExampleSentences"` // Toggle for example sentences files
-	MaxExampleSentences      int  `yaml:"maxExampleSentences"`      // Maximum number of example sentences per word
+	IncludePhonetic             bool           `yaml:"includePhonetic"`
+	IncludeOrigin               bool           `yaml:"includeOrigin"`
+	IncludeSynonyms             bool           `yaml:"includeSynonyms"`
+	IncludeAntonyms             bool           `yaml:"includeAntonyms"`
+	FilterNoExample             bool           `yaml:"filterDefinitionsWithoutExamples"`
+	GenerateExplanations        bool           `yaml:"generateExplanations"`        // Toggle for explanation files
+	GenerateExampleSentences    bool           `yaml:"generateExampleSentences"`    // Toggle for example sentences files
+	MaxExampleSentences         int            `yaml:"maxExampleSentences"`         // Maximum number of example sentences per word
+	SkipEmptyFiles              bool           `yaml:"skipEmptyFiles"`              // Don't create output files that would be empty
+	GenerateGlossary            bool           `yaml:"generateGlossary"`            // Toggle for the alphabetical glossary file
+	HandleAbbreviations         bool           `yaml:"handleAbbreviations"`         // Collect known abbreviations like "e.g." into their own category
+	CountPunctuation            bool           `yaml:"countPunctuation"`            // Tally punctuation tokens into punctuation_stats.txt
+	WordLevelsFile              string         `yaml:"wordLevelsFile"`              // JSON file mapping words to CEFR levels (A1-C2)
+	ExampleMaxLevel             string         `yaml:"exampleMaxLevel"`             // Exclude examples containing words above this CEFR level
+	FilterStopWords             bool           `yaml:"filterStopWords"`             // Drop stop words used as function words
+	DownloadAudio               bool           `yaml:"downloadAudio"`               // Download pronunciation audio into an audio/ subdirectory
+	AudioDownloadWorkers        int            `yaml:"audioDownloadWorkers"`        // Number of concurrent audio downloads
+	SortOrder                   string         `yaml:"sortOrder"`                   // Word list order: "frequency", "alphabetical" or "difficulty"
+	MaxOutputWords              int            `yaml:"maxOutputWords"`              // Maximum number of known words written across all categories
+	FlagAmbiguousPOS            bool           `yaml:"flagAmbiguousPOS"`            // List words tagged under several categories in ambiguous_words.txt
+	GenerateCloze               bool           `yaml:"generateCloze"`               // Export source sentences as cloze cards in cloze.tsv
+	PreviousOutputsDir          string         `yaml:"previousOutputsDir"`          // Exclude words listed in _AllWords.txt files under this directory
+	IncludeRankStats            bool           `yaml:"includeRankStats"`            // Annotate AllWords entries with frequency rank and cumulative coverage
+	GeneratePDF                 bool           `yaml:"generatePDF"`                 // Toggle for the printable PDF study sheet
+	JSONLogFile                 string         `yaml:"jsonLogFile"`                 // Also write a structured JSON log to this file
+	RawMode                     bool           `yaml:"rawMode"`                     // Treat the input as one normalized word per line and skip NLP
+	HighlightStyle              string         `yaml:"highlightStyle"`              // Mark the headword in example sentences: "", "asterisks" or "uppercase"
+	UseSourceExamples           bool           `yaml:"useSourceExamples"`           // Use sentences from the input text as example sentences
+	SourceExampleStrategy       string         `yaml:"sourceExampleStrategy"`       // Order of source examples: "first" or "coverage"
+	IncludeSenseCount           bool           `yaml:"includeSenseCount"`           // Append the number of definitions to words in the word lists
+	OnDirCollision              string         `yaml:"onDirCollision"`              // When a file blocks the output directory: "error", "suffix" or "timestamp"
+	GenerateGraph               bool           `yaml:"generateGraph"`               // Toggle for the synonym/antonym graph file
+	GraphFormat                 string         `yaml:"graphFormat"`                 // Graph file format: "dot" or "graphml"
+	DedupeExamplesGlobally      bool           `yaml:"dedupeExamplesGlobally"`      // Don't repeat an example sentence for another word in AllWords_es
+	GeneratePronunciation       bool           `yaml:"generatePronunciation"`       // Toggle for the word and phonetic only _pronunciation files
+	MarkMissingPhonetic         bool           `yaml:"markMissingPhonetic"`         // List words without a phonetic as "(no phonetic)" instead of skipping them
+	SamplePercent               int            `yaml:"samplePercent"`               // Process only this percentage of the input's sentences (0 or 100 = all)
+	SampleSeed                  int64          `yaml:"sampleSeed"`                  // Seed for the sentence sample, so runs are reproducible
+	GroupByFirstLetter          bool           `yaml:"groupByFirstLetter"`          // Also write the known words as alphabetized per-letter files
+	FilterDefinitionsByCategory bool           `yaml:"filterDefinitionsByCategory"` // In category files, show only senses matching the category's part of speech
+	UnknownWordsContext         bool           `yaml:"unknownWordsContext"`         // Write unknown_context.csv with each unknown word's frequency and a source sentence
+	AdaptiveConcurrency         bool           `yaml:"adaptiveConcurrency"`         // Scale audio downloads up to AudioDownloadWorkers, backing off on 429/5xx
+	GroupWordFamilies           bool           `yaml:"groupWordFamilies"`           // List inflected forms beneath their lemma instead of as separate words
+	SeparateProperNouns         bool           `yaml:"separateProperNouns"`         // Move words only capitalized mid-sentence into their own ProperNouns file
+	CategoryHeaders             bool           `yaml:"categoryHeaders"`             // Start each category file with a "== Category ==" header line
+	GlossaryFile                string         `yaml:"glossaryFile"`                // JSON or CSV of your own definitions, used before the cache and the API
+	MinKnownCoverage            float64        `yaml:"minKnownCoverage"`            // Fail the run when the known fraction of looked-up words is below this (0 disables)
+	MaxLinesPerFile             int            `yaml:"maxLinesPerFile"`             // Split word list, explanation and example files into _partN files of at most this many lines (0 = unlimited)
+	GenerateThesaurus           bool           `yaml:"generateThesaurus"`           // Toggle for thesaurus.txt listing each known word's synonyms and antonyms
+	DiverseExamples             bool           `yaml:"diverseExamples"`             // Pick one example per sense before a second from any sense
+	ExtractEntities             bool           `yaml:"extractEntities"`             // List named entities (PERSON, GPE, ORG, ...) by label in entities.txt
+	MergeExampleSources         bool           `yaml:"mergeExampleSources"`         // With UseSourceExamples, mix source and dictionary examples tagged [source]/[dict]
+	StrictPhonetic              bool           `yaml:"strictPhonetic"`              // Drop phonetics that can't be cleaned instead of storing them as-is
+	ExampleCountByLevel         map[string]int `yaml:"exampleCountByLevel"`         // Example cap per CEFR level of the word, overriding MaxExampleSentences
+	WriteProcessedIndex         bool           `yaml:"writeProcessedIndex"`         // Append a JSON line per completed word to processed.jsonl as the run goes
+	FilterLowQualityDefinitions bool           `yaml:"filterLowQualityDefinitions"` // Drop cross-reference definitions like "plural of" and very short ones
+	MinDefinitionWords          int            `yaml:"minDefinitionWords"`          // Definitions with fewer words count as low quality
 }
 
 type QueryConfig struct {
-	QueryForUnknownWords bool `yaml:"queryForUnknownWords"` // Whether to query unknown words
+	QueryForUnknownWords bool     `yaml:"queryForUnknownWords"` // Whether to query unknown words
+	DictionaryAPIURL     string   `yaml:"dictionaryAPIURL"`     // URL template taking the language code and the word
+	DictionaryLanguage   string   `yaml:"dictionaryLanguage"`   // Primary dictionary language code
+	FallbackLanguages    []string `yaml:"fallbackLanguages"`    // Languages tried in order when a word is not found in the primary one
+	AllowedHosts         []string `yaml:"allowedHosts"`         // Hosts the tool may contact; empty allows any host
+	RequestTimeout       int      `yaml:"requestTimeout"`       // Seconds before an HTTP request is abandoned
+	MaxResponseBytes     int64    `yaml:"maxResponseBytes"`     // Largest dictionary response body read before giving up
 }
 
 type ProxyConfig struct {
@@ -49,7 +112,7 @@ type ProxyConfig struct {
 type Definition struct {
 	PartOfSpeech string
 	Definition   string
-	Example      string
+	Examples     []string
 	Synonyms     []string
 	Antonyms     []string
 }
@@ -57,20 +120,88 @@ type Definition struct {
 type WordCache struct {
 	Definitions []Definition
 	Phonetic    string
+	Audio       string // URL of the pronunciation audio, if provided
+	Language    string // Dictionary language that provided the entry
 	Origin      string
 	Synonyms    []string
 	Antonyms    []string
 }
 
-// Global variables
-var config OutputConfig
-var queryConfig QueryConfig
-var proxyConfig ProxyConfig
-var wordCache = make(map[string]WordCache)
-var wordUnknown = make(map[string]bool)
-var cachePath = "word_cache.json"
-var unknownPath = "word_unknown.json"
-var logFile *os.File
+type Abbreviation struct {
+	Display   string
+	Expansion string
+}
+
+// Common abbreviations recognized when HandleAbbreviations is enabled, keyed by lowercase form
+var abbreviations = map[string]Abbreviation{
+	"e.g.":    {Display: "e.g.", Expansion: "for example"},
+	"i.e.":    {Display: "i.e.", Expansion: "that is"},
+	"etc.":    {Display: "etc.", Expansion: "et cetera"},
+	"vs.":     {Display: "vs.", Expansion: "versus"},
+	"cf.":     {Display: "cf.", Expansion: "compare"},
+	"a.m.":    {Display: "a.m.", Expansion: "before noon"},
+	"p.m.":    {Display: "p.m.", Expansion: "after noon"},
+	"mr.":     {Display: "Mr.", Expansion: "mister"},
+	"mrs.":    {Display: "Mrs.", Expansion: "mistress"},
+	"ms.":     {Display: "Ms.", Expansion: "title for a woman"},
+	"dr.":     {Display: "Dr.", Expansion: "doctor"},
+	"st.":     {Display: "St.", Expansion: "saint or street"},
+	"u.s.":    {Display: "U.S.", Expansion: "United States"},
+	"u.k.":    {Display: "U.K.", Expansion: "United Kingdom"},
+	"u.n.":    {Display: "U.N.", Expansion: "United Nations"},
+	"e.u.":    {Display: "E.U.", Expansion: "European Union"},
+	"no.":     {Display: "No.", Expansion: "number"},
+	"approx.": {Display: "approx.", Expansion: "approximately"},
+}
+
+// Readable names for the punctuation marks reported in punctuation_stats.txt
+var punctuationNames = map[string]string{
+	",":   "Comma",
+	".":   "Period",
+	";":   "Semicolon",
+	":":   "Colon",
+	"!":   "Exclamation mark",
+	"?":   "Question mark",
+	"-":   "Hyphen",
+	"--":  "Dash",
+	"(":   "Opening parenthesis",
+	")":   "Closing parenthesis",
+	"\"":  "Quotation mark",
+	"'":   "Apostrophe",
+	"...": "Ellipsis",
+}
+
+// Stop words dropped when FilterStopWords is enabled and they are used as function words
+var stopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "or": true, "but": true, "nor": true,
+	"if": true, "then": true, "so": true, "as": true, "of": true, "at": true, "by": true,
+	"for": true, "with": true, "about": true, "to": true, "from": true, "in": true, "on": true,
+	"into": true, "over": true, "under": true, "up": true, "down": true, "out": true, "off": true,
+	"i": true, "me": true, "my": true, "we": true, "our": true, "you": true, "your": true,
+	"he": true, "him": true, "his": true, "she": true, "her": true, "it": true, "its": true,
+	"they": true, "them": true, "their": true, "this": true, "that": true, "these": true, "those": true,
+	"who": true, "whom": true, "which": true, "what": true, "when": true, "where": true, "why": true, "how": true,
+	"is": true, "am": true, "are": true, "was": true, "were": true, "be": true, "been": true, "being": true,
+	"do": true, "does": true, "did": true, "have": true, "has": true, "had": true,
+	"can": true, "could": true, "will": true, "would": true, "shall": true, "should": true,
+	"may": true, "might": true, "must": true, "not": true, "no": true, "there": true, "here": true,
+}
+
+// Word categories in the order their files are written, so random example picks and
+// processed.jsonl come out the same on every run
+var categoryOrder = []string{"Nouns", "Verbs", "Adjectives", "Adverbs", "OtherWords"}
+
+// CEFR levels from easiest to hardest
+var cefrLevels = []string{"A1", "A2", "B1", "B2", "C1", "C2"}
+
+// Runs the prose NLP pipeline over the input; tests replace it to check when it runs
+var newDocument = prose.NewDocument
+
+// Default locations of the word cache and the unknown-word list
+const (
+	defaultCachePath   = "word_cache.json"
+	defaultUnknownPath = "word_unknown.json"
+)
 
 // Helper functions
 func isEnglishText(text string) bool {
@@ -85,8 +216,174 @@ func isEnglishText(text string) bool {
 	return true
 }
 
+// Returns the lowercase key of a recognized abbreviation. Only tokens containing a
+// period are considered, so "us" is never mistaken for "U.S."
+func lookupAbbreviation(token string) (string, bool) {
+	token = strings.ToLower(strings.TrimSpace(token))
+	if !strings.Contains(token, ".") {
+		return "", false
+	}
+	if !strings.HasSuffix(token, ".") {
+		token += "."
+	}
+	if _, ok := abbreviations[token]; !ok {
+		return "", false
+	}
+	return token, true
+}
+
+// Trims leading and trailing non-letter characters that cling to a token, such as
+// "word," or "(hello)", while keeping internal hyphens and apostrophes ("co-op.").
+// Clitics the tokenizer splits off ("'s", "'re", "'ll") are not words and become empty.
+func trimTokenPunctuation(token string) string {
+	if isClitic(token) {
+		return ""
+	}
+	return strings.TrimFunc(token, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+}
+
+// Reports whether a token is an apostrophe followed by one or two letters, like "'s" or "'ll"
+func isClitic(token string) bool {
+	rest, ok := strings.CutPrefix(token, "'")
+	if !ok {
+		rest, ok = strings.CutPrefix(token, "’")
+	}
+	if !ok {
+		return false
+	}
+	letters := []rune(rest)
+	if len(letters) == 0 || len(letters) > 2 {
+		return false
+	}
+	for _, r := range letters {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the lowercase words that look like proper nouns: capitalized mid-sentence and
+// never written in lowercase there. Capitalization at the start of a sentence says nothing,
+// so words only ever capitalized there are not flagged.
+func findLikelyProperNouns(sentences []string) map[string]bool {
+	type casing struct {
+		MidUpper int
+		MidLower int
+	}
+	stats := make(map[string]*casing)
+	for _, sentence := range sentences {
+		for i, field := range strings.Fields(sentence) {
+			word := trimTokenPunctuation(field)
+			if word == "" || i == 0 {
+				continue
+			}
+			key := strings.ToLower(word)
+			if stats[key] == nil {
+				stats[key] = &casing{}
+			}
+			if unicode.IsUpper([]rune(word)[0]) {
+				stats[key].MidUpper++
+			} else {
+				stats[key].MidLower++
+			}
+		}
+	}
+
+	properNouns := make(map[string]bool)
+	for word, c := range stats {
+		if word != "i" && c.MidUpper > 0 && c.MidLower == 0 {
+			properNouns[word] = true
+		}
+	}
+	return properNouns
+}
+
+// Records, for each unique input word, the filter that left it out of the output
+// ("" while it is still headed for the output), so the summary can reconcile counts
+type wordFilterTally map[string]string
+
+// Marks a word as headed for the output, overriding filters that dropped other occurrences
+func (t wordFilterTally) keep(word string) {
+	t[word] = ""
+}
+
+// Records a filter for a word no occurrence of which has been seen yet
+func (t wordFilterTally) filter(word string, reason string) {
+	if _, seen := t[word]; !seen {
+		t[word] = reason
+	}
+}
+
+// Removes a word that was headed for the output at a later stage
+func (t wordFilterTally) drop(word string, reason string) {
+	if current, seen := t[word]; seen && current == "" {
+		t[word] = reason
+	}
+}
+
+// Returns the number of unique words that reached the output and the number removed by each
+// filter; together they add up to len(t)
+func (t wordFilterTally) counts() (output int, removed map[string]int) {
+	removed = make(map[string]int)
+	for _, reason := range t {
+		if reason == "" {
+			output++
+		} else {
+			removed[reason]++
+		}
+	}
+	return output, removed
+}
+
+func isPunctuation(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, r := range text {
+		if !unicode.IsPunct(r) && !unicode.IsSymbol(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether a word should be dropped as a stop word. Only instances tagged with a
+// function-word part of speech are dropped, so content senses like "a can" or "a will" survive.
+func isStopWord(word string, tag string) bool {
+	word = strings.ToLower(word)
+	if !stopWords[word] {
+		return false
+	}
+	switch tag {
+	case "NN", "NNS", "NNP", "NNPS", "JJ", "JJR", "JJS":
+		return false
+	case "VB", "VBD", "VBP", "VBZ", "VBG", "VBN":
+		// Auxiliaries like "is" and "have" carry verb tags too; only modals tagged
+		// as verbs ("to can fruit", "to will a fortune") are lexical uses
+		switch word {
+		case "can", "will", "may", "must":
+			return false
+		}
+	}
+	return true
+}
+
+// Collapses runs of whitespace inside a phrase to single spaces and trims it,
+// so "New  York" and "New York" are treated as the same phrase
+func normalizeWhitespace(phrase string) string {
+	return strings.Join(strings.Fields(phrase), " ")
+}
+
+// Returns the key a word or phrase is stored under in the cache and unknown-words maps
+func wordKey(word string) string {
+	return strings.ToLower(normalizeWhitespace(word))
+}
+
 func capitalizePhrase(phrase string) string {
-	words := strings.Fields(phrase)
+	words := strings.Fields(phrase) // Also collapses internal whitespace, like normalizeWhitespace
 	for i, word := range words {
 		if len(word) > 0 {
 			words[i] = strings.ToUpper(string(word[0])) + strings.ToLower(word[1:])
@@ -95,17 +392,38 @@ func capitalizePhrase(phrase string) string {
 	return strings.Join(words, " ")
 }
 
+// Uppercases the first letter of a sentence. Leading quotes, brackets and other punctuation
+// are skipped; sentences starting with a number, or with a word cased like "iPhone" or "eBay"
+// (lowercase first letter, uppercase later), are returned unchanged.
 func capitalizeSentence(sentence string) string {
-	if len(sentence) == 0 {
-		return ""
+	runes := []rune(sentence)
+	start := 0
+	for start < len(runes) && (unicode.IsPunct(runes[start]) || unicode.IsSpace(runes[start])) {
+		start++
+	}
+	if start == len(runes) || !unicode.IsLower(runes[start]) {
+		return sentence
+	}
+
+	// Leave intentionally lowercase brand-style words alone
+	for i := start + 1; i < len(runes) && unicode.IsLetter(runes[i]); i++ {
+		if unicode.IsUpper(runes[i]) {
+			return sentence
+		}
 	}
-	return strings.ToUpper(string(sentence[0])) + sentence[1:]
+
+	runes[start] = unicode.ToUpper(runes[start])
+	return string(runes)
 }
 
+// Splits "a/b" style tokens into their parts, dropping empty and whitespace-only parts
+// such as those produced by "//" or a trailing slash
 func splitSlashSeparatedWords(text string) []string {
-	parts := strings.Split(text, "/")
-	for i, part := range parts {
-		parts[i] = strings.TrimSpace(part)
+	var parts []string
+	for _, part := range strings.Split(text, "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
 	}
 	return parts
 }
@@ -127,8 +445,12 @@ func sortByFrequency(counts map[string]int) []string {
 	for item, freq := range counts {
 		items = append(items, itemFreq{Item: item, Freq: freq})
 	}
+	// Ties are alphabetical so the order doesn't depend on map iteration
 	sort.Slice(items, func(i, j int) bool {
-		return items[i].Freq > items[j].Freq
+		if items[i].Freq != items[j].Freq {
+			return items[i].Freq > items[j].Freq
+		}
+		return items[i].Item < items[j].Item
 	})
 	var result []string
 	for _, item := range items {
@@ -137,6 +459,58 @@ func sortByFrequency(counts map[string]int) []string {
 	return result
 }
 
+func sortAlphabetically(items []string) []string {
+	result := make([]string, len(items))
+	copy(result, items)
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i]) < strings.ToLower(result[j])
+	})
+	return result
+}
+
+// Sorts words from easiest to hardest CEFR level, then by frequency (highest first),
+// then alphabetically so the order is deterministic. Words without a level sort last.
+func (c *classifier) sortByDifficulty(counts map[string]int) []string {
+	var result []string
+	for item := range counts {
+		result = append(result, item)
+	}
+	rank := func(item string) int {
+		r := levelRank(c.WordLevels[strings.ToLower(item)])
+		if r < 0 {
+			return len(cefrLevels)
+		}
+		return r
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ri, rj := rank(result[i]), rank(result[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if counts[result[i]] != counts[result[j]] {
+			return counts[result[i]] > counts[result[j]]
+		}
+		return result[i] < result[j]
+	})
+	return result
+}
+
+// Sorts words according to the configured SortOrder
+func (c *classifier) sortWords(counts map[string]int) []string {
+	switch c.Config.SortOrder {
+	case "alphabetical":
+		var items []string
+		for item := range counts {
+			items = append(items, item)
+		}
+		return sortAlphabetically(items)
+	case "difficulty":
+		return c.sortByDifficulty(counts)
+	default:
+		return sortByFrequency(counts)
+	}
+}
+
 // Configuration loading
 func loadInputConfig() InputConfig {
 	defaultConfig := InputConfig{
@@ -165,14 +539,63 @@ func loadInputConfig() InputConfig {
 
 func loadConfig() OutputConfig {
 	defaultConfig := OutputConfig{
-		IncludePhonetic:          false,
-		IncludeOrigin:            false,
-		IncludeSynonyms:          false,
-		IncludeAntonyms:          false,
-		FilterNoExample:          false,
-		GenerateExplanations:     true, // Default to true for backward compatibility
-		GenerateExampleSentences: true, // Default to true for example sentences files
-		MaxExampleSentences:      0,    // Default to 0 (no limit)
+		IncludePhonetic:             false,
+		IncludeOrigin:               false,
+		IncludeSynonyms:             false,
+		IncludeAntonyms:             false,
+		FilterNoExample:             false,
+		GenerateExplanations:        true, // Default to true for backward compatibility
+		GenerateExampleSentences:    true, // Default to true for example sentences files
+		MaxExampleSentences:         0,    // Default to 0 (no limit)
+		SkipEmptyFiles:              true, // Default to true to avoid cluttering the output directory
+		GenerateGlossary:            false,
+		HandleAbbreviations:         false,
+		CountPunctuation:            false,
+		WordLevelsFile:              "word_levels.json",
+		ExampleMaxLevel:             "", // Default to no level filtering
+		FilterStopWords:             false,
+		DownloadAudio:               false,
+		AudioDownloadWorkers:        4,
+		SortOrder:                   "frequency",
+		MaxOutputWords:              0, // Default to 0 (no limit)
+		FlagAmbiguousPOS:            false,
+		GenerateCloze:               false,
+		PreviousOutputsDir:          "", // Default to no exclusion
+		IncludeRankStats:            false,
+		GeneratePDF:                 false,
+		JSONLogFile:                 "", // Default to no JSON log
+		RawMode:                     false,
+		HighlightStyle:              "", // Default to no highlighting
+		UseSourceExamples:           false,
+		SourceExampleStrategy:       "first",
+		IncludeSenseCount:           false,
+		OnDirCollision:              "error",
+		GenerateGraph:               false,
+		GraphFormat:                 "dot",
+		DedupeExamplesGlobally:      false,
+		GeneratePronunciation:       false,
+		MarkMissingPhonetic:         false,
+		SamplePercent:               0, // Default to 0 (no sampling)
+		SampleSeed:                  1,
+		GroupByFirstLetter:          false,
+		FilterDefinitionsByCategory: false,
+		UnknownWordsContext:         false,
+		AdaptiveConcurrency:         false,
+		GroupWordFamilies:           false,
+		SeparateProperNouns:         false,
+		CategoryHeaders:             false,
+		GlossaryFile:                "",
+		MinKnownCoverage:            0,
+		MaxLinesPerFile:             0,
+		GenerateThesaurus:           false,
+		DiverseExamples:             false,
+		ExtractEntities:             false,
+		MergeExampleSources:         false,
+		StrictPhonetic:              false,
+		ExampleCountByLevel:         map[string]int{},
+		WriteProcessedIndex:         false,
+		FilterLowQualityDefinitions: false,
+		MinDefinitionWords:          3,
 	}
 
 	configPath := "outputConfig.yml"
@@ -187,7 +610,8 @@ func loadConfig() OutputConfig {
 		return defaultConfig
 	}
 
-	var config OutputConfig
+	// Start from the defaults so options missing from older config files keep their default values
+	config := defaultConfig
 	if err := yaml.Unmarshal(yamlFile, &config); err != nil {
 		return defaultConfig
 	}
@@ -197,6 +621,12 @@ func loadConfig() OutputConfig {
 func loadQueryConfig() QueryConfig {
 	defaultConfig := QueryConfig{
 		QueryForUnknownWords: false, // Default: don't query unknown words
+		DictionaryAPIURL:     "https://api.dictionaryapi.dev/api/v2/entries/%s/%s",
+		DictionaryLanguage:   "en",
+		FallbackLanguages:    []string{},
+		AllowedHosts:         []string{},
+		RequestTimeout:       10,
+		MaxResponseBytes:     5 << 20, // 5 MB
 	}
 
 	configPath := "queryConfig.yml"
@@ -211,7 +641,8 @@ func loadQueryConfig() QueryConfig {
 		return defaultConfig
 	}
 
-	var config QueryConfig
+	// Start from the defaults so options missing from older config files keep their default values
+	config := defaultConfig
 	if err := yaml.Unmarshal(yamlFile, &config); err != nil {
 		return defaultConfig
 	}
@@ -244,53 +675,336 @@ func loadProxyConfig() ProxyConfig {
 }
 
 // Cache management
-func loadWordCache() {
-	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
-		return
+
+// Version of the word_cache.json layout written by this build.
+// Version 1 files are a bare word map whose definitions hold a single Example string;
+// version 2 wraps the map with a version number and stores Examples as a slice.
+const wordCacheVersion = 2
+
+type wordCacheFile struct {
+	Version int                  `json:"version"`
+	Words   map[string]WordCache `json:"words"`
+}
+
+// Upgrades the raw word map of a cache file from the keyed version to the next one
+var wordCacheMigrations = map[int]func(json.RawMessage) (json.RawMessage, error){
+	1: migrateWordCacheV1,
+}
+
+// v1 -> v2: each definition's "Example" string becomes an "Examples" slice
+func migrateWordCacheV1(words json.RawMessage) (json.RawMessage, error) {
+	var entries map[string]map[string]interface{}
+	if err := json.Unmarshal(words, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		definitions, _ := entry["Definitions"].([]interface{})
+		for _, d := range definitions {
+			def, ok := d.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			// Unversioned caches may already store Examples; only build it from Example when absent
+			if _, ok := def["Examples"]; !ok {
+				examples := []interface{}{}
+				if example, ok := def["Example"].(string); ok && example != "" {
+					examples = append(examples, example)
+				}
+				def["Examples"] = examples
+			}
+			delete(def, "Example")
+		}
+	}
+	return json.Marshal(entries)
+}
+
+// Decodes a cache file of any known version, migrating it to the current layout
+func decodeWordCache(data []byte) (map[string]WordCache, error) {
+	var probe struct {
+		Version *int            `json:"version"`
+		Words   json.RawMessage `json:"words"`
+	}
+	version := 1
+	words := json.RawMessage(data)
+	if err := json.Unmarshal(data, &probe); err == nil && probe.Version != nil {
+		version = *probe.Version
+		words = probe.Words
+	}
+
+	if version > wordCacheVersion {
+		return nil, fmt.Errorf("cache version %d is newer than supported version %d", version, wordCacheVersion)
+	}
+	for ; version < wordCacheVersion; version++ {
+		migrate, ok := wordCacheMigrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration from cache version %d", version)
+		}
+		migrated, err := migrate(words)
+		if err != nil {
+			return nil, fmt.Errorf("migrating cache version %d: %v", version, err)
+		}
+		words = migrated
+	}
+
+	cache := make(map[string]WordCache)
+	if len(words) > 0 {
+		if err := json.Unmarshal(words, &cache); err != nil {
+			return nil, err
+		}
+	}
+	return cache, nil
+}
+
+// Loads the word cache from path. readOnly is set when the file exists but cannot be
+// decoded (e.g. it comes from a newer build), so it is never overwritten.
+func loadWordCache(path string, logger *slog.Logger) (cache map[string]WordCache, readOnly bool) {
+	cache = make(map[string]WordCache)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return cache, false
 	}
 
-	data, err := ioutil.ReadFile(cachePath)
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return
+		return cache, false
 	}
 
-	if err := json.Unmarshal(data, &wordCache); err != nil {
-		wordCache = make(map[string]WordCache)
+	decoded, err := decodeWordCache(data)
+	if err != nil {
+		// Leave the file alone rather than silently replacing it with an empty cache
+		logger.Warn("word cache not loaded; it will not be overwritten this run", "file", path, "error", err)
+		return cache, true
 	}
+	return decoded, false
 }
 
-func saveWordCache() {
-	data, err := json.MarshalIndent(wordCache, "", "  ")
+func (c *classifier) saveWordCache() {
+	if c.CachePath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(wordCacheFile{Version: wordCacheVersion, Words: c.Cache}, "", "  ")
 	if err != nil {
 		return
 	}
-	ioutil.WriteFile(cachePath, data, 0644)
+	ioutil.WriteFile(c.CachePath, data, 0644)
 }
 
-func loadWordUnknown() {
-	if _, err := os.Stat(unknownPath); os.IsNotExist(err) {
-		return
+func loadWordUnknown(path string) map[string]bool {
+	unknown := make(map[string]bool)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return unknown
 	}
 
-	data, err := ioutil.ReadFile(unknownPath)
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return
+		return unknown
 	}
 
-	if err := json.Unmarshal(data, &wordUnknown); err != nil {
-		wordUnknown = make(map[string]bool)
+	if err := json.Unmarshal(data, &unknown); err != nil {
+		return make(map[string]bool)
 	}
+	return unknown
 }
 
-func saveWordUnknown() {
-	data, err := json.MarshalIndent(wordUnknown, "", "  ")
+func (c *classifier) saveWordUnknown() {
+	if c.UnknownPath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(c.Unknown, "", "  ")
 	if err != nil {
 		return
 	}
-	ioutil.WriteFile(unknownPath, data, 0644)
+	ioutil.WriteFile(c.UnknownPath, data, 0644)
+}
+
+func loadWordLevels(path string, logger *slog.Logger) map[string]string {
+	wordLevels := make(map[string]string)
+	if path == "" {
+		return wordLevels
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return wordLevels
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return wordLevels
+	}
+
+	var levels map[string]string
+	if err := json.Unmarshal(data, &levels); err != nil {
+		logger.Warn("ignoring malformed word levels file", "file", path, "error", err)
+		return wordLevels
+	}
+	for word, level := range levels {
+		wordLevels[strings.ToLower(word)] = strings.ToUpper(level)
+	}
+	return wordLevels
+}
+
+// One definition from the user's glossary. CSV glossaries have the columns
+// word, definition, partOfSpeech (with an optional header row); JSON glossaries are an
+// array of these objects.
+type GlossaryEntry struct {
+	Word         string `json:"word"`
+	Definition   string `json:"definition"`
+	PartOfSpeech string `json:"partOfSpeech"`
+}
+
+// Loads the user's glossary, keyed by word. A word may have several entries,
+// which become its definitions in file order.
+func loadGlossaryFile(path string, logger *slog.Logger) map[string]WordCache {
+	glossary := make(map[string]WordCache)
+	if path == "" {
+		return glossary
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Warn("ignoring unreadable glossary file", "file", path, "error", err)
+		return glossary
+	}
+
+	var entries []GlossaryEntry
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		reader := csv.NewReader(strings.NewReader(string(data)))
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil {
+			logger.Warn("ignoring malformed glossary file", "file", path, "error", err)
+			return glossary
+		}
+		for i, record := range records {
+			// Skip an optional header row
+			if i == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "word") {
+				continue
+			}
+			entry := GlossaryEntry{Word: record[0]}
+			if len(record) > 1 {
+				entry.Definition = record[1]
+			}
+			if len(record) > 2 {
+				entry.PartOfSpeech = record[2]
+			}
+			entries = append(entries, entry)
+		}
+	} else if err := json.Unmarshal(data, &entries); err != nil {
+		logger.Warn("ignoring malformed glossary file", "file", path, "error", err)
+		return glossary
+	}
+
+	for _, entry := range entries {
+		word := wordKey(entry.Word)
+		definition := strings.TrimSpace(entry.Definition)
+		if word == "" || definition == "" {
+			continue
+		}
+		glossaryEntry := glossary[word]
+		glossaryEntry.Definitions = append(glossaryEntry.Definitions, Definition{
+			PartOfSpeech: strings.ToLower(strings.TrimSpace(entry.PartOfSpeech)),
+			Definition:   definition,
+		})
+		glossary[word] = glossaryEntry
+	}
+	logger.Info("loaded glossary", "file", path, "words", len(glossary))
+	return glossary
+}
+
+// Returns the position of a CEFR level (0 for A1 up to 5 for C2), or -1 if unrecognized
+func levelRank(level string) int {
+	level = strings.ToUpper(strings.TrimSpace(level))
+	for i, l := range cefrLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the rank of the hardest leveled word in an example, ignoring the headword itself.
+// Returns -1 if no word in the example has a known level.
+func (c *classifier) exampleLevelRank(example string, headword string) int {
+	hardest := -1
+	words := strings.FieldsFunc(strings.ToLower(example), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\'' && r != '-'
+	})
+	for _, w := range words {
+		if w == headword {
+			continue
+		}
+		if rank := levelRank(c.WordLevels[w]); rank > hardest {
+			hardest = rank
+		}
+	}
+	return hardest
+}
+
+// Matches _AllWords.txt files, including the _AllWords_partN.txt parts of a split one
+var allWordsFilePattern = regexp.MustCompile(`_AllWords(_part\d+)?\.txt$`)
+
+// Loads the words listed in every _AllWords.txt file found under dir
+func loadPreviousWords(dir string, logger *slog.Logger) map[string]bool {
+	previousWords := make(map[string]bool)
+	if dir == "" {
+		return previousWords
+	}
+
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !allWordsFilePattern.MatchString(info.Name()) {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			logger.Warn("skipping previous output file", "file", path, "error", err)
+			return nil
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// Drop any annotation such as " (rank 1, 5.0%)" after the word
+			line, _, _ := strings.Cut(scanner.Text(), " (")
+
+			// A word family's inflected forms are listed beneath its lemma
+			if forms, ok := strings.CutPrefix(line, "\tForms: "); ok {
+				for _, form := range strings.Split(forms, ", ") {
+					if word := strings.ToLower(strings.TrimSpace(form)); word != "" {
+						previousWords[word] = true
+					}
+				}
+				continue
+			}
+			if word := strings.ToLower(strings.TrimSpace(line)); word != "" {
+				previousWords[word] = true
+			}
+		}
+		return nil
+	})
+	return previousWords
+}
+
+// Refuses requests to hosts that are not on the allowlist before they leave the process
+type allowlistTransport struct {
+	allowed map[string]bool
+	next    http.RoundTripper
+}
+
+func newAllowlistTransport(hosts []string, next http.RoundTripper) *allowlistTransport {
+	allowed := make(map[string]bool)
+	for _, host := range hosts {
+		allowed[strings.ToLower(strings.TrimSpace(host))] = true
+	}
+	return &allowlistTransport{allowed: allowed, next: next}
 }
 
-func createHTTPClient() *http.Client {
+func (t *allowlistTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	if !t.allowed[host] {
+		return nil, fmt.Errorf("request to host %q blocked: not in allowedHosts", host)
+	}
+	return t.next.RoundTrip(req)
+}
+
+func createHTTPClient(queryConfig QueryConfig, proxyConfig ProxyConfig) *http.Client {
 	transport := &http.Transport{}
 
 	if proxyConfig.HTTPSProxy != "" {
@@ -305,18 +1019,28 @@ func createHTTPClient() *http.Client {
 		}
 	}
 
+	var roundTripper http.RoundTripper = transport
+	if len(queryConfig.AllowedHosts) > 0 {
+		roundTripper = newAllowlistTransport(queryConfig.AllowedHosts, transport)
+	}
+
+	timeout := queryConfig.RequestTimeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+
 	return &http.Client{
-		Timeout:   10 * time.Second,
-		Transport: transport,
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: roundTripper,
 	}
 }
 
 // Check if a word has details available, returns true if it has details, false if not
-func hasWordDetails(word string) bool {
-	word = strings.ToLower(word)
+func (c *classifier) hasWordDetails(word string) bool {
+	word = wordKey(word)
 
 	// Check if the word is in the cache
-	cachedData, exists := wordCache[word]
+	cachedData, exists := c.Cache[word]
 	if exists && len(cachedData.Definitions) > 0 {
 		return true
 	}
@@ -324,169 +1048,190 @@ func hasWordDetails(word string) bool {
 	return false
 }
 
-// Query the dictionary API for a word
-func queryDictionaryAPI(word string) bool {
-	apiURL := fmt.Sprintf("https://api.dictionaryapi.dev/api/v2/entries/en/%s", word)
-
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return false
-	}
-
-	req.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-	req.Header.Add("Accept", "application/json")
-
-	client := createHTTPClient()
-	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return false
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, _ := ioutil.ReadAll(resp.Body)
-
-	var result []map[string]interface{}
-	if err := json.Unmarshal(bodyBytes, &result); err != nil || len(result) == 0 {
-		return false
-	}
-
-	// Process API response into cache structure
-	cachedData := WordCache{
-		Definitions: []Definition{},
-		Phonetic:    "",
-		Origin:      "",
-		Synonyms:    []string{},
-		Antonyms:    []string{},
+// Cleans a phonetic from the dictionary: HTML entities are unescaped and only the first of
+// several variants ("/ˈhæpi/ /ˈhæp.i/" or "ˈhæpi, ˈhæp.i") is kept. ok is false when the
+// result doesn't look like a phonetic, in which case the unescaped text is returned as-is.
+func cleanPhonetic(phonetic string) (cleaned string, ok bool) {
+	phonetic = strings.TrimSpace(html.UnescapeString(phonetic))
+	if phonetic == "" {
+		return "", true
 	}
 
-	// Extract phonetic if available
-	if phonetic, ok := result[0]["phonetic"].(string); ok {
-		cachedData.Phonetic = phonetic
+	cleaned = phonetic
+	if closing := map[byte]string{'/': "/", '[': "]"}[phonetic[0]]; closing != "" {
+		if end := strings.Index(phonetic[1:], closing); end >= 0 {
+			cleaned = phonetic[:end+2]
+		}
+	} else if variants := strings.FieldsFunc(phonetic, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == ';'
+	}); len(variants) > 0 {
+		cleaned = variants[0]
 	}
 
-	// Extract phonetics
-	if phonetics, ok := result[0]["phonetics"].([]interface{}); ok && cachedData.Phonetic == "" {
-		for _, p := range phonetics {
-			if phoneticMap, ok := p.(map[string]interface{}); ok {
-				if text, ok := phoneticMap["text"].(string); ok && text != "" {
-					cachedData.Phonetic = text
-					break
-				}
-			}
+	hasLetter := false
+	for _, r := range cleaned {
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		}
+		if unicode.IsDigit(r) || strings.ContainsRune("<>{}=&@#$%*_|\\", r) {
+			return phonetic, false
 		}
 	}
-
-	// Extract origin directly from the top level
-	if originStr, ok := result[0]["origin"].(string); ok {
-		cachedData.Origin = originStr
+	if !hasLetter {
+		return phonetic, false
 	}
+	return cleaned, true
+}
 
-	// Extract meanings, definitions, synonyms, antonyms
-	if meanings, ok := result[0]["meanings"].([]interface{}); ok {
-		for _, m := range meanings {
-			if meaningMap, ok := m.(map[string]interface{}); ok {
-				partOfSpeech := ""
-				if pos, ok := meaningMap["partOfSpeech"].(string); ok {
-					partOfSpeech = pos
-				}
+// Query the dictionary provider for a word in the primary language, then in each fallback
+// language, and cache the first entry found
+func (c *classifier) queryDictionaryAPI(word string) bool {
+	languages := append([]string{c.QueryConfig.DictionaryLanguage}, c.QueryConfig.FallbackLanguages...)
+	for _, language := range languages {
+		if language == "" {
+			continue
+		}
 
-				// Extract definitions
-				if definitions, ok := meaningMap["definitions"].([]interface{}); ok {
-					for _, d := range definitions {
-						defMap, ok := d.(map[string]interface{})
-						if !ok {
-							continue
-						}
-
-						def := Definition{
-							PartOfSpeech: partOfSpeech,
-							Definition:   "",
-							Example:      "",
-							Synonyms:     []string{},
-							Antonyms:     []string{},
-						}
-
-						if defStr, ok := defMap["definition"].(string); ok {
-							def.Definition = defStr
-						}
-
-						if exampleStr, ok := defMap["example"].(string); ok {
-							def.Example = exampleStr
-						}
-
-						// Extract synonyms and antonyms
-						if syns, ok := defMap["synonyms"].([]interface{}); ok {
-							for _, syn := range syns {
-								if synStr, ok := syn.(string); ok {
-									def.Synonyms = append(def.Synonyms, synStr)
-									cachedData.Synonyms = append(cachedData.Synonyms, synStr)
-								}
-							}
-						}
-
-						if ants, ok := defMap["antonyms"].([]interface{}); ok {
-							for _, ant := range ants {
-								if antStr, ok := ant.(string); ok {
-									def.Antonyms = append(def.Antonyms, antStr)
-									cachedData.Antonyms = append(cachedData.Antonyms, antStr)
-								}
-							}
-						}
-
-						cachedData.Definitions = append(cachedData.Definitions, def)
-					}
-				}
+		entry, err := c.Provider.Lookup(word, language)
+		if err != nil {
+			if !errors.Is(err, errWordNotFound) {
+				c.Logger.Warn("dictionary request failed", "word", word, "language", language, "error", err)
 			}
+			continue
 		}
-	}
 
-	// Save to cache if we found definitions
-	if len(cachedData.Definitions) > 0 {
-		wordCache[strings.ToLower(word)] = cachedData
-		saveWordCache()
+		if language != c.QueryConfig.DictionaryLanguage {
+			c.Logger.Info("found in fallback language", "word", word, "language", language)
+		}
+		entry.Language = language
+		if phonetic, ok := cleanPhonetic(entry.Phonetic); ok || !c.Config.StrictPhonetic {
+			entry.Phonetic = phonetic
+		} else {
+			c.Logger.Info("dropping unusable phonetic", "word", word, "phonetic", entry.Phonetic)
+			entry.Phonetic = ""
+		}
+		c.Cache[wordKey(word)] = entry
+		c.saveWordCache()
 		return true
 	}
-
 	return false
 }
 
-// Fetches word details and returns formatted output (if available) or empty string for unknown words
-func fetchWordDetails(word string) string {
-	word = strings.ToLower(word)
-
-	// Check if the word is in the unknown words database
-	if _, isUnknown := wordUnknown[word]; isUnknown {
-		// If configured not to query unknown words, return empty string
-		if !queryConfig.QueryForUnknownWords {
-			return ""
+// Definitions that only point at another entry rather than explain the word
+var crossReferenceDefinition = regexp.MustCompile(`(?i)^\(?(plural of|past tense of|past participle of|present participle of|simple past of|third-person singular .*of|alternative (form|spelling) of|obsolete (form|spelling) of|misspelling of|see also|see)\b`)
+
+// Returns the indexes of definitions FilterLowQualityDefinitions drops: cross-references
+// and definitions shorter than MinDefinitionWords. If that would drop them all, the best
+// one is kept: the longest, preferring definitions that aren't cross-references.
+func (c *classifier) lowQualityDefinitions(definitions []Definition) map[int]bool {
+	lowQuality := make(map[int]bool)
+	if !c.Config.FilterLowQualityDefinitions {
+		return lowQuality
+	}
+	best, bestScore := -1, 0
+	for i, def := range definitions {
+		wordCount := len(strings.Fields(def.Definition))
+		crossReference := crossReferenceDefinition.MatchString(strings.TrimSpace(def.Definition))
+		if crossReference || wordCount < c.Config.MinDefinitionWords {
+			lowQuality[i] = true
 		}
 
-		// Try to query API for this previously unknown word
-		if !queryDictionaryAPI(word) {
-			// Still unknown, return empty string
-			return ""
+		score := wordCount
+		if !crossReference {
+			score += 1000
+		}
+		if best < 0 || score > bestScore {
+			best, bestScore = i, score
 		}
-
-		// The word now has details, remove from unknown list
-		delete(wordUnknown, word)
-		saveWordUnknown()
+	}
+	if len(lowQuality) == len(definitions) {
+		delete(lowQuality, best)
+	}
+	return lowQuality
+}
+
+// Returns the first definition FilterLowQualityDefinitions keeps, used where a word gets a
+// single definition. definitions must not be empty.
+func (c *classifier) primaryDefinition(definitions []Definition) Definition {
+	lowQuality := c.lowQualityDefinitions(definitions)
+	for i, def := range definitions {
+		if !lowQuality[i] {
+			return def
+		}
+	}
+	return definitions[0]
+}
+
+// Dictionary part of speech matching each word category file
+var categoryPartsOfSpeech = map[string]string{
+	"Nouns":      "noun",
+	"Verbs":      "verb",
+	"Adjectives": "adjective",
+	"Adverbs":    "adverb",
+}
+
+// Returns the part of speech definitions should be restricted to for a category,
+// or "" when FilterDefinitionsByCategory is off or no definition matches
+func (c *classifier) definitionFilterPartOfSpeech(definitions []Definition, category string) string {
+	if !c.Config.FilterDefinitionsByCategory {
+		return ""
+	}
+	partOfSpeech := categoryPartsOfSpeech[category]
+	if partOfSpeech == "" {
+		return ""
+	}
+	for _, def := range definitions {
+		if strings.EqualFold(def.PartOfSpeech, partOfSpeech) {
+			return partOfSpeech
+		}
+	}
+	return ""
+}
+
+// Fetches word details and returns formatted output (if available) or empty string for unknown words.
+// category is the category file being written ("" for files that aren't per category).
+func (c *classifier) fetchWordDetails(word string, category string) string {
+	word = wordKey(word)
+
+	// The user's glossary is authoritative: its entries replace cached ones and never need the API
+	if glossaryEntry, ok := c.Glossary[word]; ok {
+		c.Cache[word] = glossaryEntry
+		delete(c.Unknown, word)
+	}
+
+	// Check if the word is in the unknown words database
+	if _, isUnknown := c.Unknown[word]; isUnknown {
+		// If configured not to query unknown words, return empty string
+		if !c.QueryConfig.QueryForUnknownWords {
+			return ""
+		}
+
+		// Try to query API for this previously unknown word
+		if !c.queryDictionaryAPI(word) {
+			// Still unknown, return empty string
+			return ""
+		}
+
+		// The word now has details, remove from unknown list
+		delete(c.Unknown, word)
+		c.saveWordUnknown()
 	}
 
 	// Check if the word is in the cache
-	cachedData, exists := wordCache[word]
+	cachedData, exists := c.Cache[word]
 
 	// If not in cache, try to fetch from API
 	if !exists {
 		// Try to query API
-		if !queryDictionaryAPI(word) {
+		if !c.queryDictionaryAPI(word) {
 			// Not found, add to unknown words and return empty
-			wordUnknown[word] = true
-			saveWordUnknown()
+			c.Unknown[word] = true
+			c.saveWordUnknown()
 			return ""
 		}
 
 		// Now it should be in cache
-		cachedData = wordCache[word]
+		cachedData = c.Cache[word]
 	}
 
 	// Format output with the layout
@@ -494,28 +1239,41 @@ func fetchWordDetails(word string) string {
 	capitalized := capitalizePhrase(word)
 
 	// Put word and phonetic on the same line
-	if cachedData.Phonetic != "" && config.IncludePhonetic {
+	if cachedData.Phonetic != "" && c.Config.IncludePhonetic {
 		output.WriteString(fmt.Sprintf("%s %s\n", capitalized, cachedData.Phonetic))
 	} else {
 		output.WriteString(fmt.Sprintf("%s\n", capitalized))
 	}
 
+	// Note entries that came from a fallback language
+	if cachedData.Language != "" && cachedData.Language != c.QueryConfig.DictionaryLanguage {
+		output.WriteString(fmt.Sprintf("\tLanguage: %s\n", cachedData.Language))
+	}
+
 	// Add origin if available and enabled
-	if config.IncludeOrigin && cachedData.Origin != "" {
+	if c.Config.IncludeOrigin && cachedData.Origin != "" {
 		output.WriteString(fmt.Sprintf("\tOrigin: %s\n", cachedData.Origin))
 	}
 
 	// Check if there are definitions available
 	if len(cachedData.Definitions) == 0 {
 		// This shouldn't happen after our checks, but just in case
-		wordUnknown[word] = true
-		saveWordUnknown()
+		c.Unknown[word] = true
+		c.saveWordUnknown()
 		return ""
 	}
 
 	// Process definitions with the new format
+	partOfSpeech := c.definitionFilterPartOfSpeech(cachedData.Definitions, category)
+	lowQuality := c.lowQualityDefinitions(cachedData.Definitions)
 	for i, def := range cachedData.Definitions {
-		if config.FilterNoExample && def.Example == "" {
+		if c.Config.FilterNoExample && len(def.Examples) == 0 {
+			continue
+		}
+		if lowQuality[i] {
+			continue
+		}
+		if partOfSpeech != "" && !strings.EqualFold(def.PartOfSpeech, partOfSpeech) {
 			continue
 		}
 
@@ -525,20 +1283,20 @@ func fetchWordDetails(word string) string {
 		output.WriteString(fmt.Sprintf("\t%s %d, %s: %s\n",
 			capitalized, defNumber, def.PartOfSpeech, def.Definition))
 
-		// Add example if available, with word and number prefix
-		if def.Example != "" {
+		// Add examples if available, with word and number prefix
+		for _, example := range def.Examples {
 			output.WriteString(fmt.Sprintf("\t\t%s %d Example: %s\n",
-				capitalized, defNumber, def.Example))
+				capitalized, defNumber, example))
 		}
 
 		// Add synonyms if enabled and available, with word and number prefix
-		if config.IncludeSynonyms && len(def.Synonyms) > 0 {
+		if c.Config.IncludeSynonyms && len(def.Synonyms) > 0 {
 			output.WriteString(fmt.Sprintf("\t\t%s %d Synonyms: %s\n",
 				capitalized, defNumber, strings.Join(def.Synonyms, ", ")))
 		}
 
 		// Add antonyms if enabled and available, with word and number prefix
-		if config.IncludeAntonyms && len(def.Antonyms) > 0 {
+		if c.Config.IncludeAntonyms && len(def.Antonyms) > 0 {
 			output.WriteString(fmt.Sprintf("\t\t%s %d Antonyms: %s\n",
 				capitalized, defNumber, strings.Join(def.Antonyms, ", ")))
 		}
@@ -548,15 +1306,17 @@ func fetchWordDetails(word string) string {
 }
 
 // Function to generate example sentences file for a word with the new selection logic
-func generateExampleSentencesContent(word string) string {
-	word = strings.ToLower(word)
+// sourceExamples are the input sentences containing the word, already ranked by rankSourceExamples.
+// If emitted is not nil, examples already in it are skipped and the selected ones are added to it.
+func (c *classifier) generateExampleSentencesContent(word string, sourceExamples []string, emitted map[string]bool, rng *rand.Rand) string {
+	word = wordKey(word)
 
 	// Check if the word is unknown - if so, return empty string
-	if _, isUnknown := wordUnknown[word]; isUnknown {
+	if _, isUnknown := c.Unknown[word]; isUnknown {
 		return ""
 	}
 
-	cachedData, exists := wordCache[word]
+	cachedData, exists := c.Cache[word]
 	if !exists || len(cachedData.Definitions) == 0 {
 		return ""
 	}
@@ -564,99 +1324,1070 @@ func generateExampleSentencesContent(word string) string {
 	// Collect all example sentences for this word
 	var exampleSentences []string
 	for _, def := range cachedData.Definitions {
-		if def.Example != "" {
+		for _, example := range def.Examples {
 			// Make sure the first letter is capitalized
-			example := capitalizeSentence(def.Example)
-			exampleSentences = append(exampleSentences, example)
+			exampleSentences = append(exampleSentences, capitalizeSentence(example))
+		}
+	}
+
+	// Order examples round-robin across senses and keep that order, so the selection
+	// covers as many senses as possible before taking a second example from any
+	rankedExamples := false
+	if c.Config.DiverseExamples {
+		exampleSentences = nil
+		for round := 0; ; round++ {
+			added := false
+			for _, def := range cachedData.Definitions {
+				if round < len(def.Examples) {
+					exampleSentences = append(exampleSentences, capitalizeSentence(def.Examples[round]))
+					added = true
+				}
+			}
+			if !added {
+				break
+			}
+		}
+		rankedExamples = true
+	}
+
+	var rankedSourceExamples []string
+	if c.Config.UseSourceExamples {
+		for _, example := range sourceExamples {
+			rankedSourceExamples = append(rankedSourceExamples, capitalizeSentence(example))
+		}
+	}
+
+	// Drops examples already written for another word and those whose hardest word is
+	// above the configured level
+	filterExamples := func(examples []string) []string {
+		maxRank := levelRank(c.Config.ExampleMaxLevel)
+		var kept []string
+		for _, example := range examples {
+			if emitted != nil && emitted[wordKey(example)] {
+				continue
+			}
+			if maxRank >= 0 && c.exampleLevelRank(example, word) > maxRank {
+				continue
+			}
+			kept = append(kept, example)
+		}
+		return kept
+	}
+	exampleSentences = filterExamples(exampleSentences)
+	rankedSourceExamples = filterExamples(rankedSourceExamples)
+
+	// Selected examples, with the provenance tag of each when sources are merged
+	var selectedExamples, exampleTags []string
+	if c.Config.MergeExampleSources && len(rankedSourceExamples) > 0 && len(exampleSentences) > 0 {
+		sourceCount, dictCount := splitExampleCap(c.exampleCap(word), len(rankedSourceExamples), len(exampleSentences))
+		for _, example := range selectExamples(rankedSourceExamples, sourceCount, true, rng) {
+			selectedExamples = append(selectedExamples, example)
+			exampleTags = append(exampleTags, "[source] ")
+		}
+		for _, example := range selectExamples(exampleSentences, dictCount, rankedExamples, rng) {
+			selectedExamples = append(selectedExamples, example)
+			exampleTags = append(exampleTags, "[dict] ")
 		}
+	} else {
+		// Prefer sentences from the input text when enabled; they are already ranked, so the
+		// best ones are kept instead of a random selection
+		if len(rankedSourceExamples) > 0 {
+			exampleSentences = rankedSourceExamples
+			rankedExamples = true
+		}
+		selectedExamples = selectExamples(exampleSentences, c.exampleCap(word), rankedExamples, rng)
 	}
 
-	if len(exampleSentences) == 0 {
+	if len(selectedExamples) == 0 {
 		return ""
 	}
 
-	// Apply the selection logic based on MaxExampleSentences setting
-	var selectedExamples []string
+	if emitted != nil {
+		for _, example := range selectedExamples {
+			emitted[wordKey(example)] = true
+		}
+	}
+
+	// Format the output
+	var output strings.Builder
+	capitalized := capitalizePhrase(word)
+	output.WriteString(capitalized)
+
+	for i, example := range selectedExamples {
+		tag := ""
+		if exampleTags != nil {
+			tag = exampleTags[i]
+		}
+		output.WriteString("\n\t" + tag + highlightWord(example, word, c.Config.HighlightStyle))
+	}
+
+	return output.String()
+}
 
-	// If MaxExampleSentences is 0 (no limit) or greater than/equal to available examples,
+// Returns the maximum number of examples for a word: the ExampleCountByLevel entry for
+// its CEFR level if there is one, otherwise MaxExampleSentences
+func (c *classifier) exampleCap(word string) int {
+	if level, ok := c.WordLevels[wordKey(word)]; ok {
+		for configuredLevel, count := range c.Config.ExampleCountByLevel {
+			if strings.EqualFold(strings.TrimSpace(configuredLevel), level) {
+				return count
+			}
+		}
+	}
+	return c.Config.MaxExampleSentences
+}
+
+// Picks up to limit examples (0 = all): the first ones when they are ranked, otherwise a
+// random selection
+func selectExamples(examples []string, limit int, ranked bool, rng *rand.Rand) []string {
+	// If limit is 0 (no limit) or greater than/equal to available examples,
 	// use all available examples
-	if config.MaxExampleSentences <= 0 || config.MaxExampleSentences >= len(exampleSentences) {
-		selectedExamples = exampleSentences
+	if limit <= 0 || limit >= len(examples) {
+		return examples
+	}
+	if ranked {
+		return examples[:limit]
+	}
+
+	// Create a copy of examples to avoid modifying the original
+	availableExamples := make([]string, len(examples))
+	copy(availableExamples, examples)
+
+	// Randomly select examples
+	selectedExamples := make([]string, 0, limit)
+	for i := 0; i < limit && len(availableExamples) > 0; i++ {
+		// Pick a random index
+		randIndex := rng.Intn(len(availableExamples))
+
+		// Add the example at the random index to selected examples
+		selectedExamples = append(selectedExamples, availableExamples[randIndex])
+
+		// Remove the selected example to avoid duplicates
+		availableExamples = append(availableExamples[:randIndex], availableExamples[randIndex+1:]...)
+	}
+	return selectedExamples
+}
+
+// Splits the example cap between source and dictionary examples: half each, with the
+// source getting the odd one, and any share one side can't fill going to the other.
+// A cap of 0 takes every example from both.
+func splitExampleCap(limit int, sourceAvailable int, dictAvailable int) (sourceCount int, dictCount int) {
+	if limit <= 0 {
+		return sourceAvailable, dictAvailable
+	}
+	sourceCount = min((limit+1)/2, sourceAvailable)
+	dictCount = min(limit-sourceCount, dictAvailable)
+	sourceCount = min(limit-dictCount, sourceAvailable)
+	return sourceCount, dictCount
+}
+
+// Builds the alphabetical glossary of known words with their primary definition.
+// Words classified under more than one category list those categories in brackets.
+func (c *classifier) generateGlossaryContent(wordCategories map[string][]string) string {
+	var words []string
+	for word := range wordCategories {
+		words = append(words, word)
+	}
+
+	var output strings.Builder
+	for _, word := range sortAlphabetically(words) {
+		cachedData, exists := c.Cache[wordKey(word)]
+		if !exists || len(cachedData.Definitions) == 0 {
+			continue
+		}
+
+		output.WriteString(capitalizePhrase(word))
+		if categories := wordCategories[word]; len(categories) > 1 {
+			output.WriteString(" [" + strings.Join(sortAlphabetically(categories), ", ") + "]")
+		}
+
+		primary := c.primaryDefinition(cachedData.Definitions)
+		if primary.PartOfSpeech != "" {
+			output.WriteString(" (" + primary.PartOfSpeech + ")")
+		}
+		output.WriteString(": " + capitalizeSentence(primary.Definition) + "\n")
+	}
+
+	return output.String()
+}
+
+// Walks words from most to least frequent, looking them up until budget known words are found.
+// Returns the set of words beyond the budget, in frequency order; words before the cut-off,
+// known or unknown, are processed as usual.
+func (c *classifier) selectWordBudget(counts map[string]int, budget int) []string {
+	var words []string
+	for word := range counts {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+
+	known := 0
+	for i, word := range words {
+		if known >= budget {
+			return words[i:]
+		}
+		if c.fetchWordDetails(word, "") != "" {
+			known++
+		}
+	}
+	return nil
+}
+
+// Builds entities.txt: each entity label alphabetically, followed by its entities with
+// their frequencies, most frequent first
+func generateEntitiesContent(entities []prose.Entity) string {
+	labelCounts := make(map[string]map[string]int)
+	for _, entity := range entities {
+		text := normalizeWhitespace(entity.Text)
+		if text == "" {
+			continue
+		}
+		if labelCounts[entity.Label] == nil {
+			labelCounts[entity.Label] = map[string]int{}
+		}
+		labelCounts[entity.Label][text]++
+	}
+
+	var labels []string
+	for label := range labelCounts {
+		labels = append(labels, label)
+	}
+
+	var output strings.Builder
+	for _, label := range sortAlphabetically(labels) {
+		output.WriteString(label + "\n")
+		for _, text := range sortByFrequency(labelCounts[label]) {
+			output.WriteString(fmt.Sprintf("\t%s (%d)\n", text, labelCounts[label][text]))
+		}
+	}
+	return output.String()
+}
+
+// Removes repeated words, ignoring case and surrounding whitespace, keeping the first occurrence
+func dedupeWords(words []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		key := strings.ToLower(word)
+		if word == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, word)
+	}
+	return result
+}
+
+// Formats a thesaurus line such as "Happy — syn: glad, joyful; ant: sad" from the word's
+// synonyms and antonyms across all its definitions. ok is false when it has neither.
+func (c *classifier) formatThesaurusLine(word string) (line string, ok bool) {
+	cachedData := c.Cache[wordKey(word)]
+	synonyms := cachedData.Synonyms
+	antonyms := cachedData.Antonyms
+	for _, def := range cachedData.Definitions {
+		synonyms = append(synonyms, def.Synonyms...)
+		antonyms = append(antonyms, def.Antonyms...)
+	}
+	synonyms = dedupeWords(synonyms)
+	antonyms = dedupeWords(antonyms)
+	if len(synonyms) == 0 && len(antonyms) == 0 {
+		return "", false
+	}
+
+	var parts []string
+	if len(synonyms) > 0 {
+		parts = append(parts, "syn: "+strings.Join(synonyms, ", "))
+	}
+	if len(antonyms) > 0 {
+		parts = append(parts, "ant: "+strings.Join(antonyms, ", "))
+	}
+	return capitalizePhrase(word) + " — " + strings.Join(parts, "; "), true
+}
+
+// Returns the words that were classified under more than one category, alphabetically,
+// each formatted with its per-category tallies
+func findAmbiguousWords(wordCategoryCounts map[string]map[string]int) []string {
+	var words []string
+	for word, categoryCounts := range wordCategoryCounts {
+		if len(categoryCounts) > 1 {
+			words = append(words, word)
+		}
+	}
+
+	var result []string
+	for _, word := range sortAlphabetically(words) {
+		categoryCounts := wordCategoryCounts[word]
+		var categories []string
+		for category := range categoryCounts {
+			categories = append(categories, category)
+		}
+		var tallies []string
+		for _, category := range sortAlphabetically(categories) {
+			tallies = append(tallies, fmt.Sprintf("%s %d", category, categoryCounts[category]))
+		}
+		result = append(result, fmt.Sprintf("%s (ambiguous POS): %s", capitalizePhrase(word), strings.Join(tallies, ", ")))
+	}
+	return result
+}
+
+// Matches a word case-insensitively as a whole word
+func wordPattern(word string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(word) + `\b`)
+}
+
+// Returns the first source sentence that contains the word, or an empty string
+func findSourceSentence(sentences []string, word string) string {
+	pattern := wordPattern(word)
+	for _, sentence := range sentences {
+		if pattern.MatchString(sentence) {
+			return sentence
+		}
+	}
+	return ""
+}
+
+// Replaces every occurrence of the word in the sentence with numbered cloze deletions
+// ("{{c1::word}}", "{{c2::word}}", ...), keeping the original casing of each occurrence
+func clozeSentence(sentence string, word string) string {
+	n := 0
+	return wordPattern(word).ReplaceAllStringFunc(sentence, func(match string) string {
+		n++
+		return fmt.Sprintf("{{c%d::%s}}", n, match)
+	})
+}
+
+// Marks every case-insensitive whole-word occurrence of the word in the sentence.
+// Adjacent punctuation is left outside the markers, e.g. "top," becomes "*top*,".
+func highlightWord(sentence string, word string, style string) string {
+	var mark func(string) string
+	switch style {
+	case "asterisks":
+		mark = func(match string) string { return "*" + match + "*" }
+	case "uppercase":
+		mark = strings.ToUpper
+	default:
+		return sentence
+	}
+	return wordPattern(word).ReplaceAllStringFunc(sentence, mark)
+}
+
+// Splits a sentence into its distinct lowercase words
+func sentenceWords(sentence string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(sentence), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\'' && r != '-'
+	}) {
+		words[w] = true
+	}
+	return words
+}
+
+// Collects, for each study word, the input sentences that contain it. With the "coverage"
+// strategy sentences are ordered by how much other study vocabulary they contain (the summed
+// frequency of the other study words in them); otherwise they stay in document order.
+func rankSourceExamples(counts map[string]int, sentences []string, strategy string) map[string][]string {
+	sentenceWordSets := make([]map[string]bool, len(sentences))
+	scores := make([]int, len(sentences))
+	wordSentences := make(map[string][]int)
+	for i, sentence := range sentences {
+		sentenceWordSets[i] = sentenceWords(sentence)
+		for w := range sentenceWordSets[i] {
+			if _, isStudyWord := counts[w]; isStudyWord {
+				wordSentences[w] = append(wordSentences[w], i)
+				scores[i] += counts[w]
+			}
+		}
+	}
+
+	ranked := make(map[string][]string)
+	for word, indexes := range wordSentences {
+		if strategy == "coverage" {
+			// The target word itself contributes equally to every candidate, so it doesn't affect the order
+			sort.SliceStable(indexes, func(a, b int) bool {
+				return scores[indexes[a]] > scores[indexes[b]]
+			})
+		}
+		for _, i := range indexes {
+			ranked[word] = append(ranked[word], sentences[i])
+		}
+	}
+	return ranked
+}
+
+// Keeps a value on a single TSV field
+func tsvField(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Builds unknown_context.csv: a header, then one row per word with its frequency and
+// the first input sentence containing it
+func generateUnknownContextCSV(words []string, counts map[string]int, sentences []string) (string, error) {
+	var output strings.Builder
+	writer := csv.NewWriter(&output)
+	writer.Write([]string{"word", "frequency", "sentence"})
+	for _, word := range words {
+		writer.Write([]string{word, strconv.Itoa(counts[word]), tsvField(findSourceSentence(sentences, word))})
+	}
+	writer.Flush()
+	return output.String(), writer.Error()
+}
+
+// Builds cloze.tsv rows: the source sentence with the word deleted, then its primary definition.
+// When audioFiles is non-nil (audio downloads enabled) a third field references the word's
+// downloaded audio as an Anki [sound:...] tag, left empty for words without audio.
+func (c *classifier) generateClozeContent(knownWords []string, sentences []string, audioFiles map[string]string) string {
+	var output strings.Builder
+	for _, word := range knownWords {
+		lowerWord := wordKey(word)
+		cachedData, exists := c.Cache[lowerWord]
+		if !exists || len(cachedData.Definitions) == 0 {
+			continue
+		}
+		sentence := findSourceSentence(sentences, lowerWord)
+		if sentence == "" {
+			continue
+		}
+
+		primary := c.primaryDefinition(cachedData.Definitions)
+		back := capitalizePhrase(word)
+		if primary.PartOfSpeech != "" {
+			back += " (" + primary.PartOfSpeech + ")"
+		}
+		back += ": " + primary.Definition
+		output.WriteString(tsvField(clozeSentence(sentence, lowerWord)) + "\t" + tsvField(back))
+		if audioFiles != nil {
+			output.WriteString("\t")
+			if localPath, ok := audioFiles[lowerWord]; ok {
+				output.WriteString("[sound:" + filepath.Base(localPath) + "]")
+			}
+		}
+		output.WriteString("\n")
+	}
+	return output.String()
+}
+
+type RankStat struct {
+	Rank     int     // 1 = most frequent
+	Coverage float64 // Percentage of all word occurrences covered by this word and every more frequent one
+}
+
+// Computes each word's frequency rank and cumulative coverage percentage.
+// Ties in frequency are ranked alphabetically so the result is deterministic.
+func computeRankStats(counts map[string]int) map[string]RankStat {
+	var words []string
+	total := 0
+	for word, count := range counts {
+		words = append(words, word)
+		total += count
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+
+	stats := make(map[string]RankStat)
+	cumulative := 0
+	for i, word := range words {
+		cumulative += counts[word]
+		stats[word] = RankStat{
+			Rank:     i + 1,
+			Coverage: float64(cumulative) / float64(total) * 100,
+		}
+	}
+	return stats
+}
+
+// Number of cached definitions of a word that survive the output filters, matching the
+// senses fetchWordDetails lists for the word in category ("" outside category files)
+func (c *classifier) senseCount(word string, category string) int {
+	definitions := c.Cache[wordKey(word)].Definitions
+	partOfSpeech := c.definitionFilterPartOfSpeech(definitions, category)
+	lowQuality := c.lowQualityDefinitions(definitions)
+	count := 0
+	for i, def := range definitions {
+		if c.Config.FilterNoExample && len(def.Examples) == 0 {
+			continue
+		}
+		if lowQuality[i] {
+			continue
+		}
+		if partOfSpeech != "" && !strings.EqualFold(def.PartOfSpeech, partOfSpeech) {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// Formats a word for the word list files with the enabled annotations, e.g.
+// "Run (12) (rank 3, 10.5%)"; category is the word's category file, or "" for AllWords
+func (c *classifier) formatWordLine(word string, category string, rankStats map[string]RankStat) string {
+	line := capitalizePhrase(word)
+	if c.Config.IncludeSenseCount {
+		line += fmt.Sprintf(" (%d)", c.senseCount(word, category))
+	}
+	if stat, ok := rankStats[strings.ToLower(word)]; ok {
+		line += fmt.Sprintf(" (rank %d, %.1f%%)", stat.Rank, stat.Coverage)
+	}
+	return line
+}
+
+// Formats a "Word  /phonetic/" line from the cached phonetic. Words without a phonetic
+// are marked when MarkMissingPhonetic is set and skipped (ok is false) otherwise.
+func (c *classifier) formatPronunciationLine(word string) (line string, ok bool) {
+	phonetic := strings.TrimSpace(c.Cache[wordKey(word)].Phonetic)
+	if phonetic == "" {
+		if !c.Config.MarkMissingPhonetic {
+			return "", false
+		}
+		return capitalizePhrase(word) + "  (no phonetic)", true
+	}
+	if !strings.HasPrefix(phonetic, "/") && !strings.HasPrefix(phonetic, "[") {
+		phonetic = "/" + phonetic + "/"
+	}
+	return capitalizePhrase(word) + "  " + phonetic, true
+}
+
+// Groups words by their uppercase first letter, alphabetized within each group.
+// Words that don't start with a letter go into the "#" group.
+func groupByFirstLetter(words []string) map[string][]string {
+	groups := make(map[string][]string)
+	for _, word := range words {
+		group := "#"
+		if r := []rune(word); len(r) > 0 && unicode.IsLetter(r[0]) {
+			group = string(unicode.ToUpper(r[0]))
+		}
+		groups[group] = append(groups[group], word)
+	}
+	for group, groupWords := range groups {
+		groups[group] = sortAlphabetically(groupWords)
+	}
+	return groups
+}
+
+func (c *classifier) printProgress(stage string, item string, current, total int) {
+	percentage := int((float64(current) / float64(total)) * 100)
+	fmt.Fprintf(c.Out, "\r%-80s", " ") // Clear line
+	fmt.Fprintf(c.Out, "\r%s: %s (%d of %d) - %d%%", stage, capitalizePhrase(item), current, total, percentage)
+}
+
+// Output file writer that creates its file on the first write when SkipEmptyFiles is enabled,
+// so outputs without any content are never produced. Safe for concurrent use.
+type outputWriter struct {
+	mu         sync.Mutex
+	path       string
+	file       io.WriteCloser
+	writer     *bufio.Writer
+	written    bool
+	header     string
+	headerOnce sync.Once
+	maxLines   int                      // Lines per part before starting a new one; 0 keeps a single file
+	lines      int                      // Lines written to the current part
+	part       int                      // Current part number, 0 until the output is first split
+	sink       map[string]*bytes.Buffer // Collects the output in memory instead of on disk when set
+	appendOnly bool                     // Adds to an existing file instead of replacing it
+}
+
+func (c *classifier) newOutputWriter(path string) (*outputWriter, error) {
+	return c.startOutputWriter(&outputWriter{path: path, sink: c.outputSink})
+}
+
+// Like newOutputWriter, but keeps what an existing file already holds
+func (c *classifier) newAppendingOutputWriter(path string) (*outputWriter, error) {
+	return c.startOutputWriter(&outputWriter{path: path, sink: c.outputSink, appendOnly: true})
+}
+
+// Opens the writer's file right away unless SkipEmptyFiles defers that to the first write
+func (c *classifier) startOutputWriter(ow *outputWriter) (*outputWriter, error) {
+	if !c.Config.SkipEmptyFiles {
+		if err := ow.open(); err != nil {
+			return nil, err
+		}
+	}
+	return ow, nil
+}
+
+// Path of a numbered part: "words.txt" becomes "words_part2.txt"
+func partPath(path string, part int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s_part%d%s", strings.TrimSuffix(path, ext), part, ext)
+}
+
+func (ow *outputWriter) open() error {
+	if ow.file != nil {
+		return nil
+	}
+	path := ow.path
+	if ow.part > 0 {
+		path = partPath(ow.path, ow.part)
+	}
+	if ow.sink != nil {
+		buffer := ow.sink[path]
+		if buffer == nil || !ow.appendOnly {
+			buffer = &bytes.Buffer{}
+			ow.sink[path] = buffer
+		}
+		ow.file = nopWriteCloser{buffer}
 	} else {
-		// Need to randomly select MaxExampleSentences examples
-		// Create a copy of exampleSentences to avoid modifying the original
-		availableExamples := make([]string, len(exampleSentences))
-		copy(availableExamples, exampleSentences)
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if ow.appendOnly {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := os.OpenFile(path, flag, 0666)
+		if err != nil {
+			return err
+		}
+		ow.file = file
+	}
+	ow.writer = bufio.NewWriter(ow.file)
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Renames an output file, or moves its buffer when outputs are kept in memory
+func (ow *outputWriter) renameOutput(from string, to string) error {
+	if ow.sink != nil {
+		ow.sink[to] = ow.sink[from]
+		delete(ow.sink, from)
+		return nil
+	}
+	return os.Rename(from, to)
+}
+
+// Sets a header line written exactly once per file, at the top, just before the
+// first content. Files that stay empty get no header.
+func (ow *outputWriter) SetHeader(header string) {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	ow.header = header
+}
+
+// Splits the output into numbered parts of at most maxLines lines each (0 = unlimited).
+// Each WriteString or WriteEntry call is kept whole within one part; the first part is
+// renamed to "_part1" once a second one is needed.
+func (ow *outputWriter) SetMaxLines(maxLines int) {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	ow.maxLines = maxLines
+}
+
+// Write a string as-is, creating the file first if needed
+func (ow *outputWriter) WriteString(text string) error {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	return ow.write(text, false)
+}
+
+// Write a block of text, separated from the previous block by a newline
+func (ow *outputWriter) WriteEntry(text string) error {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	return ow.write(text, true)
+}
 
-		// Randomly select examples
-		selectedExamples = make([]string, 0, config.MaxExampleSentences)
-		for i := 0; i < config.MaxExampleSentences && len(availableExamples) > 0; i++ {
-			// Pick a random index
-			randIndex := rand.Intn(len(availableExamples))
+// Number of lines text occupies, counting a final line without a newline
+func countLines(text string) int {
+	if text == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(text, "\n"), "\n") + 1
+}
+
+func (ow *outputWriter) write(text string, separate bool) error {
+	// An entry separator ends the previous entry's last line, so it adds no line of its own
+	lines := countLines(text)
+	if ow.maxLines > 0 && ow.lines > 0 && ow.lines+lines > ow.maxLines {
+		if err := ow.nextPart(); err != nil {
+			return err
+		}
+	}
+	if separate && ow.written {
+		text = "\n" + text
+	}
+
+	if err := ow.open(); err != nil {
+		return err
+	}
+	var err error
+	ow.headerOnce.Do(func() {
+		if ow.header != "" {
+			_, err = ow.writer.WriteString(ow.header + "\n")
+			ow.lines++
+		}
+	})
+	if err != nil {
+		return err
+	}
+	ow.written = true
+	ow.lines += lines
+	_, err = ow.writer.WriteString(text)
+	return err
+}
+
+// Closes the current part and moves on to the next, renaming the unsplit file to "_part1"
+func (ow *outputWriter) nextPart() error {
+	if err := ow.closeFile(); err != nil {
+		return err
+	}
+	if ow.part == 0 {
+		if err := ow.renameOutput(ow.path, partPath(ow.path, 1)); err != nil {
+			return err
+		}
+		ow.part = 1
+	}
+	ow.part++
+	ow.lines = 0
+	ow.written = false
+	ow.headerOnce = sync.Once{}
+	return nil
+}
+
+// Flush buffered content to the file without closing it
+func (ow *outputWriter) Flush() error {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	if ow.file == nil {
+		return nil
+	}
+	return ow.writer.Flush()
+}
+
+// Flush and close the file; safe to call more than once and on files never created
+func (ow *outputWriter) Close() error {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	return ow.closeFile()
+}
+
+func (ow *outputWriter) closeFile() error {
+	if ow.file == nil {
+		return nil
+	}
+	flushErr := ow.writer.Flush()
+	closeErr := ow.file.Close()
+	ow.file = nil
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+// Reads one word per line, keeping each line verbatim apart from surrounding whitespace
+func readRawWords(r io.Reader) (map[string]int, error) {
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			counts[word]++
+		}
+	}
+	return counts, scanner.Err()
+}
+
+// Fast path for preprocessed input: words are counted and written verbatim, without
+// prose, capitalization or isEnglishText, and only the word lists are produced
+func (c *classifier) categorizeRawText(r io.Reader, baseFileName string, outputDir string) error {
+	logger := c.Logger
+
+	counts, err := readRawWords(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInputFile, err)
+	}
+	logger.Info("raw mode: starting dictionary lookups", "uniqueWords", len(counts))
+
+	processed, err := c.newProcessedIndex(outputDir)
+	if err != nil {
+		return fmt.Errorf("failed to create processed.jsonl file: %v", err)
+	}
+	defer processed.Close()
+
+	var knownWords, unknownWords []string
+	sortedWords := sortByFrequency(counts)
+	for i, word := range sortedWords {
+		c.printProgress("Dictionary lookup (raw)", word, i+1, len(sortedWords))
+		if c.fetchWordDetails(word, "") == "" {
+			unknownWords = append(unknownWords, word)
+			logger.Info("word processed", "word", word, "category", "raw", "outcome", "unknown")
+			if err := processed.Record(word, "raw", "unknown"); err != nil {
+				return fmt.Errorf("failed to write processed.jsonl file: %v", err)
+			}
+			continue
+		}
+		knownWords = append(knownWords, word)
+		logger.Info("word processed", "word", word, "category", "raw", "outcome", "known")
+		if err := processed.Record(word, "raw", "known"); err != nil {
+			return fmt.Errorf("failed to write processed.jsonl file: %v", err)
+		}
+	}
+	if err := processed.Close(); err != nil {
+		return fmt.Errorf("failed to write processed.jsonl file: %v", err)
+	}
+
+	outputs := []struct {
+		Name  string
+		Words []string
+	}{
+		{Name: baseFileName + "_AllWords.txt", Words: knownWords},
+		{Name: "UnknownWords.txt", Words: unknownWords},
+	}
+	for _, out := range outputs {
+		writer, err := c.newOutputWriter(filepath.Join(outputDir, out.Name))
+		if err != nil {
+			return fmt.Errorf("failed to create %s file: %v", out.Name, err)
+		}
+		writer.SetMaxLines(c.Config.MaxLinesPerFile)
+		for _, word := range out.Words {
+			writer.WriteString(word + "\n")
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("failed to write %s file: %v", out.Name, err)
+		}
+	}
+
+	fmt.Fprintf(c.Out, "\n===== Analysis Results =====\n")
+	fmt.Fprintf(c.Out, "Known words: %d, Unknown words: %d\n", len(knownWords), len(unknownWords))
+	fmt.Fprintf(c.Out, "Results written to directory: %s\n", outputDir)
+	logger.Info("analysis results", "knownWords", len(knownWords), "unknownWords", len(unknownWords), "outputDir", outputDir)
+
+	if len(knownWords) == 0 && len(unknownWords) > 0 {
+		return errAllWordsUnknown
+	}
+	return c.checkKnownCoverage(len(knownWords), len(unknownWords))
+}
+
+// Returns the fraction of looked-up words that were found in the dictionary (1 when none were looked up)
+func knownCoverage(known int, unknown int) float64 {
+	if known+unknown == 0 {
+		return 1
+	}
+	return float64(known) / float64(known+unknown)
+}
+
+// Fails with errLowKnownCoverage when MinKnownCoverage is set and the known fraction is below it
+func (c *classifier) checkKnownCoverage(known int, unknown int) error {
+	coverage := knownCoverage(known, unknown)
+	if c.Config.MinKnownCoverage > 0 && coverage < c.Config.MinKnownCoverage {
+		return fmt.Errorf("%w: %.1f%% known, minimum is %.1f%%", errLowKnownCoverage, coverage*100, c.Config.MinKnownCoverage*100)
+	}
+	return nil
+}
+
+// Selects percent of the sentences (rounded, at least one) using the given seed and
+// returns them in their original order. The same seed always yields the same sample.
+func sampleSentences(sentences []string, percent int, seed int64) []string {
+	if len(sentences) == 0 || percent >= 100 {
+		return sentences
+	}
+	n := (len(sentences)*percent + 50) / 100
+	if n < 1 {
+		n = 1
+	}
+
+	picked := rand.New(rand.NewSource(seed)).Perm(len(sentences))[:n]
+	sort.Ints(picked)
+
+	sample := make([]string, 0, n)
+	for _, i := range picked {
+		sample = append(sample, sentences[i])
+	}
+	return sample
+}
+
+// Reports whether path exists and is not a directory
+func isNonDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
 
-			// Add the example at the random index to selected examples
-			selectedExamples = append(selectedExamples, availableExamples[randIndex])
+// Picks the output directory for an input, handling a non-directory file that already
+// uses the name according to mode: "error" fails, "suffix" appends "-1", "-2", ...,
+// and "timestamp" appends the current time. An existing directory is reused as before.
+func resolveOutputDir(base string, mode string, now time.Time) (string, error) {
+	if !isNonDirectory(base) {
+		return base, nil
+	}
 
-			// Remove the selected example to avoid duplicates
-			availableExamples = append(availableExamples[:randIndex], availableExamples[randIndex+1:]...)
+	switch mode {
+	case "suffix":
+		for i := 1; ; i++ {
+			candidate := fmt.Sprintf("%s-%d", base, i)
+			if !isNonDirectory(candidate) {
+				return candidate, nil
+			}
+		}
+	case "timestamp":
+		candidate := base + "-" + now.Format("20060102-150405")
+		if isNonDirectory(candidate) {
+			return "", fmt.Errorf("cannot create output directory %q: a file with that name already exists", candidate)
 		}
+		return candidate, nil
+	default:
+		return "", fmt.Errorf("cannot create output directory %q: a file with that name already exists (set onDirCollision to \"suffix\" or \"timestamp\" to use another name)", base)
+	}
+}
+
+// Everything categorizeText depends on besides the input file, so a run can use a fake
+// provider, an in-memory word cache, a temporary output directory and fixed time and randomness
+type Dependencies struct {
+	Config      OutputConfig
+	QueryConfig QueryConfig
+	ProxyConfig ProxyConfig
+	Provider    DictionaryProvider
+	OutputDir   string // Empty to derive it from the input file name
+	Now         func() time.Time
+	Rand        *rand.Rand
+	Logger      *slog.Logger
+	Out         io.Writer // Console progress and summaries; nil for os.Stdout
+
+	Cache       map[string]WordCache // Word cache; updated with new lookups
+	Unknown     map[string]bool      // Words the dictionary doesn't know; updated with new misses
+	WordLevels  map[string]string    // CEFR level by lowercase word
+	Glossary    map[string]WordCache // User definitions that override the dictionary
+	CachePath   string               // Where Cache is saved after lookups; empty to never save it
+	UnknownPath string               // Where Unknown is saved after lookups; empty to never save it
+}
+
+// One categorization run: the dependencies plus the state shared by the pipeline stages
+type classifier struct {
+	Dependencies
+	outputSink map[string]*bytes.Buffer // When set, output files are collected here instead of being written to disk
+}
+
+func newClassifier(deps Dependencies) *classifier {
+	if deps.Out == nil {
+		deps.Out = os.Stdout
+	}
+	if deps.Cache == nil {
+		deps.Cache = make(map[string]WordCache)
 	}
+	if deps.Unknown == nil {
+		deps.Unknown = make(map[string]bool)
+	}
+	if deps.WordLevels == nil {
+		deps.WordLevels = make(map[string]string)
+	}
+	if deps.Glossary == nil {
+		deps.Glossary = make(map[string]WordCache)
+	}
+	return &classifier{Dependencies: deps}
+}
 
-	// Format the output
-	var output strings.Builder
-	capitalized := capitalizePhrase(word)
-	output.WriteString(capitalized)
-
-	for _, example := range selectedExamples {
-		output.WriteString("\n\t" + example)
+func categorizeText(inputFile string, deps Dependencies) error {
+	// Read input file
+	file, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInputFile, err)
 	}
+	defer file.Close()
 
-	return output.String()
+	baseFileName := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+	return newClassifier(deps).categorizeReader(file, baseFileName)
 }
 
-func printProgress(stage string, item string, current, total int) {
-	percentage := int((float64(current) / float64(total)) * 100)
-	fmt.Printf("\r%-80s", " ") // Clear line
-	fmt.Printf("\r%s: %s (%d of %d) - %d%%", stage, capitalizePhrase(item), current, total, percentage)
-}
+// Runs the whole pipeline on text with cache as the word cache, and returns the output files
+// by path. Nothing is read from or written to disk, the dictionary is never queried, cache is
+// left untouched and console output is discarded, so it suits benchmarks of classification
+// and formatting.
+func categorizeInMemory(text string, cache map[string]WordCache, cfg OutputConfig) (map[string]string, error) {
+	// Features that need the network or read other files from disk are left out
+	cfg.DownloadAudio = false
+	cfg.GeneratePDF = false
+	cfg.PreviousOutputsDir = ""
+
+	// The run stores what it looks up in its cache, so it works on a copy
+	runCache := make(map[string]WordCache, len(cache))
+	for word, entry := range cache {
+		runCache[word] = entry
+	}
+
+	c := newClassifier(Dependencies{
+		Config:    cfg,
+		Provider:  mapDictionaryProvider(cache),
+		OutputDir: "output",
+		Now:       time.Now,
+		Rand:      rand.New(rand.NewSource(1)),
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Out:       io.Discard,
+		Cache:     runCache,
+	})
+	c.outputSink = make(map[string]*bytes.Buffer)
+	if err := c.categorizeReader(strings.NewReader(text), "input"); err != nil {
+		return nil, err
+	}
 
-func setupLogging() {
-	var err error
-	logFile, err = os.OpenFile("log.txt", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err == nil {
-		log.SetOutput(logFile)
-		log.SetFlags(log.LstdFlags)
+	outputs := make(map[string]string, len(c.outputSink))
+	for path, buffer := range c.outputSink {
+		outputs[path] = buffer.String()
 	}
+	return outputs, nil
 }
 
-func categorizeText(inputFile string) error {
-	baseFileName := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
-	outputDir := baseFileName
+func (c *classifier) categorizeReader(r io.Reader, baseFileName string) error {
+	logger := c.Logger
+
+	outputDir := c.OutputDir
+	if outputDir == "" {
+		var err error
+		outputDir, err = resolveOutputDir(baseFileName, c.Config.OnDirCollision, c.Now())
+		if err != nil {
+			return err
+		}
+	}
 
 	// Create output directory
-	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-		return err
+	if c.outputSink == nil {
+		if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+			return err
+		}
 	}
 
-	// Read input file
-	file, err := os.Open(inputFile)
-	if err != nil {
-		return err
+	// Preprocessed input bypasses the NLP pipeline entirely
+	if c.Config.RawMode {
+		return c.categorizeRawText(r, baseFileName, outputDir)
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	var content string
 	for scanner.Scan() {
 		content += scanner.Text() + " "
 	}
 
 	// Create NLP document
-	doc, err := prose.NewDocument(content)
+	doc, err := newDocument(content)
 	if err != nil {
 		return err
 	}
 
+	var sentences []string
+	for _, sentence := range doc.Sentences() {
+		sentences = append(sentences, sentence.Text)
+	}
+
+	// Reduce the input to a reproducible sample of its sentences
+	if c.Config.SamplePercent > 0 && c.Config.SamplePercent < 100 {
+		sentences = sampleSentences(sentences, c.Config.SamplePercent, c.Config.SampleSeed)
+		logger.Info("sampled input", "percent", c.Config.SamplePercent, "seed", c.Config.SampleSeed, "sentences", len(sentences))
+		doc, err = newDocument(strings.Join(sentences, " "))
+		if err != nil {
+			return err
+		}
+	}
+
 	// Define categories and files
 	categories := map[string]string{
 		"Nouns":      filepath.Join(outputDir, baseFileName+"_Nouns.txt"),
@@ -670,36 +2401,102 @@ func categorizeText(inputFile string) error {
 	exampleSentencesFiles := map[string]string{}
 
 	// Only create explanation file maps if the toggle is enabled
-	if config.GenerateExplanations {
+	if c.Config.GenerateExplanations {
 		for category, file := range categories {
 			explanationFiles[category] = strings.Replace(file, ".txt", "_ex.txt", 1)
 		}
 	}
 
 	// Only create example sentences file maps if the toggle is enabled
-	if config.GenerateExampleSentences {
+	if c.Config.GenerateExampleSentences {
 		for category, file := range categories {
 			exampleSentencesFiles[category] = strings.Replace(file, ".txt", "_es.txt", 1)
 		}
 	}
 
+	pronunciationFiles := map[string]string{}
+
+	// Only create pronunciation file maps if the toggle is enabled
+	if c.Config.GeneratePronunciation {
+		for category, file := range categories {
+			pronunciationFiles[category] = strings.Replace(file, ".txt", "_pronunciation.txt", 1)
+		}
+	}
+
 	categorizedWords := map[string][]string{}
 	allWords := map[string]int{}
+	abbreviationCounts := map[string]int{}
+	properNounCounts := map[string]int{}
+	filterTally := wordFilterTally{}
+	punctuationCounts := map[string]int{}
+	wordCategoryCounts := map[string]map[string]int{}
+	previousWords := loadPreviousWords(c.Config.PreviousOutputsDir, logger)
+	previousWordCounts := map[string]int{}
+
+	// Casing is judged per sentence, since any word is capitalized at the start of one
+	var likelyProperNouns map[string]bool
+	if c.Config.SeparateProperNouns {
+		likelyProperNouns = findLikelyProperNouns(sentences)
+	}
 
 	// Process tokens
 	tokens := doc.Tokens()
 	totalTokens := len(tokens)
-	log.Println("Starting text classification...")
+	logger.Info("starting text classification", "input", baseFileName, "tokens", totalTokens)
 
 	for i, tok := range tokens {
 		text := strings.ToLower(tok.Text)
-		printProgress("Classifying text", text, i+1, totalTokens)
+		c.printProgress("Classifying text", text, i+1, totalTokens)
+
+		// Named entities are listed in entities.txt and never looked up as words
+		if c.Config.ExtractEntities && tok.Label != "" && tok.Label != "O" {
+			filterTally.filter(text, "named entity")
+			continue
+		}
+
+		// Keep recognized abbreviations whole instead of letting isEnglishText drop them
+		if c.Config.HandleAbbreviations {
+			if key, ok := lookupAbbreviation(text); ok {
+				abbreviationCounts[key]++
+				filterTally.filter(key, "abbreviation")
+				continue
+			}
+		}
+
+		// Tally punctuation separately; it is never treated as a word
+		if isPunctuation(text) {
+			if c.Config.CountPunctuation {
+				punctuationCounts[text]++
+			}
+			continue
+		}
 
 		// Process slash-separated words
 		wordParts := splitSlashSeparatedWords(text)
 		for _, part := range wordParts {
-			if isEnglishText(part) {
+			part = trimTokenPunctuation(part)
+			if part != "" && !isEnglishText(part) {
+				filterTally.filter(part, "non-English")
+			}
+			if part != "" && isEnglishText(part) {
+				if c.Config.FilterStopWords && isStopWord(part, tok.Tag) {
+					filterTally.filter(part, "stop word")
+					continue
+				}
+				// Likely proper nouns are listed on their own, without dictionary lookups
+				if likelyProperNouns[part] {
+					properNounCounts[part]++
+					filterTally.filter(part, "proper noun")
+					continue
+				}
+				// Words from previous runs are counted but not processed again
+				if previousWords[part] {
+					previousWordCounts[part]++
+					filterTally.filter(part, "previous run")
+					continue
+				}
 				allWords[part]++
+				filterTally.keep(part)
 				var category string
 				switch tok.Tag {
 				case "NN", "NNS", "NNP", "NNPS":
@@ -714,15 +2511,41 @@ func categorizeText(inputFile string) error {
 					category = "OtherWords"
 				}
 				categorizedWords[category] = append(categorizedWords[category], part)
+				if wordCategoryCounts[part] == nil {
+					wordCategoryCounts[part] = map[string]int{}
+				}
+				wordCategoryCounts[part][category]++
+			}
+		}
+	}
+
+	// Fold inflected forms into their lemma so each word family is counted and listed once
+	var wordFamilies map[string][]string
+	if c.Config.GroupWordFamilies {
+		lemmas := findWordLemmas(wordCategoryCounts, allWords)
+		wordFamilies = groupWordFamilies(allWords, lemmas)
+		for word, lemma := range lemmas {
+			allWords[lemma] += allWords[word]
+			delete(allWords, word)
+			filterTally.drop(word, "word family form")
+			// The lemma takes the form's place in the output, even if it never appeared itself
+			filterTally.keep(lemma)
+		}
+		for _, words := range categorizedWords {
+			for i, word := range words {
+				if lemma, ok := lemmas[word]; ok {
+					words[i] = lemma
+				}
 			}
 		}
+		logger.Info("grouped word families", "families", len(wordFamilies))
 	}
 
-	log.Println("\nClassification complete. Starting dictionary lookups...")
-	fmt.Println("\nClassification complete. Starting dictionary lookups...")
+	logger.Info("classification complete, starting dictionary lookups", "uniqueWords", len(allWords))
+	fmt.Fprintln(c.Out, "\nClassification complete. Starting dictionary lookups...")
 
 	// Get all unique words for total word count display
-	sortedAllWords := sortByFrequency(allWords)
+	sortedAllWords := c.sortWords(allWords)
 	totalUniqueWords := len(sortedAllWords)
 
 	// Track progress across all words being processed
@@ -735,112 +2558,289 @@ func categorizeText(inputFile string) error {
 	// Map to track unknown words and their frequencies
 	uniqueUnknownWords := make(map[string]int)
 
-	// Write categorized content to individual files
-	for category, words := range categorizedWords {
-		filePath := categories[category]
+	// Candidate example sentences from the input text, ranked per word
+	var sourceExamples map[string][]string
+	if c.Config.UseSourceExamples {
+		sourceExamples = rankSourceExamples(allWords, sentences, c.Config.SourceExampleStrategy)
+	}
 
-		wordFile, err := os.Create(filePath)
-		if err != nil {
-			return fmt.Errorf("failed to create output file for %s: %v", category, err)
+	// Words left out because of the MaxOutputWords budget
+	var overflowWords []string
+	overflowSet := make(map[string]bool)
+	if c.Config.MaxOutputWords > 0 {
+		overflowWords = c.selectWordBudget(allWords, c.Config.MaxOutputWords)
+		for _, word := range overflowWords {
+			overflowSet[word] = true
+			filterTally.drop(strings.ToLower(word), "output budget")
 		}
-		defer wordFile.Close()
+	}
 
-		wordWriter := bufio.NewWriter(wordFile)
+	// Map to track the categories each known word was written to, for the glossary
+	knownWordCategories := make(map[string][]string)
 
-		var exFile *os.File
-		var exWriter *bufio.Writer
+	processed, err := c.newProcessedIndex(outputDir)
+	if err != nil {
+		return fmt.Errorf("failed to create processed.jsonl file: %v", err)
+	}
+	defer processed.Close()
+
+	// Write categorized content to individual files
+	for _, category := range categoryOrder {
+		words, ok := categorizedWords[category]
+		if !ok {
+			continue
+		}
+		wordWriter, err := c.newOutputWriter(categories[category])
+		if err != nil {
+			return fmt.Errorf("failed to create output file for %s: %v", category, err)
+		}
+		defer wordWriter.Close()
 
-		var esFile *os.File
-		var esWriter *bufio.Writer
+		var exWriter *outputWriter
+		var esWriter *outputWriter
+		var prWriter *outputWriter
 
 		// Only create explanation files if the toggle is enabled
-		if config.GenerateExplanations {
-			exFilePath := explanationFiles[category]
-			exFile, err = os.Create(exFilePath)
+		if c.Config.GenerateExplanations {
+			exWriter, err = c.newOutputWriter(explanationFiles[category])
 			if err != nil {
 				return fmt.Errorf("failed to create explanation file for %s: %v", category, err)
 			}
-			defer exFile.Close()
-			exWriter = bufio.NewWriter(exFile)
+			defer exWriter.Close()
 		}
 
 		// Only create example sentences files if the toggle is enabled
-		if config.GenerateExampleSentences {
-			esFilePath := exampleSentencesFiles[category]
-			esFile, err = os.Create(esFilePath)
+		if c.Config.GenerateExampleSentences {
+			esWriter, err = c.newOutputWriter(exampleSentencesFiles[category])
 			if err != nil {
 				return fmt.Errorf("failed to create example sentences file for %s: %v", category, err)
 			}
-			defer esFile.Close()
-			esWriter = bufio.NewWriter(esFile)
+			defer esWriter.Close()
+		}
+
+		// Only create pronunciation files if the toggle is enabled
+		if c.Config.GeneratePronunciation {
+			prWriter, err = c.newOutputWriter(pronunciationFiles[category])
+			if err != nil {
+				return fmt.Errorf("failed to create pronunciation file for %s: %v", category, err)
+			}
+			defer prWriter.Close()
 		}
 
-		sortedWords := sortByFrequency(countFrequencies(words))
+		// Headers go in before the first entry, so category files that stay empty get none
+		for _, writer := range []*outputWriter{wordWriter, exWriter, esWriter, prWriter} {
+			if writer == nil {
+				continue
+			}
+			writer.SetMaxLines(c.Config.MaxLinesPerFile)
+			if c.Config.CategoryHeaders {
+				writer.SetHeader(fmt.Sprintf("== %s ==", category))
+			}
+		}
 
-		log.Printf("\nProcessing %s category (%d words):\n", category, len(sortedWords))
-		fmt.Printf("\nProcessing %s category (%d words):\n", category, len(sortedWords))
+		sortedWords := c.sortWords(countFrequencies(words))
 
-		// Track if we've written anything to the example sentences file
-		hasWrittenExamples := false
-		// Track if we've written anything to the explanation file
-		hasWrittenExplanations := false
+		logger.Info("processing category", "category", category, "words", len(sortedWords))
+		fmt.Fprintf(c.Out, "\nProcessing %s category (%d words):\n", category, len(sortedWords))
 
 		for i, word := range sortedWords {
 			wordCounter++
-			printProgress(
+			c.printProgress(
 				fmt.Sprintf("Dictionary lookup (%s)", category),
 				word,
 				i+1,
 				len(sortedWords))
 
+			// Skip words beyond the output budget; they are listed in the overflow file
+			if overflowSet[strings.ToLower(word)] {
+				if err := processed.Record(strings.ToLower(word), category, "deferred"); err != nil {
+					return fmt.Errorf("failed to write processed.jsonl file: %v", err)
+				}
+				continue
+			}
+
 			// Fetch word details
-			wordDetailsText := fetchWordDetails(word)
+			wordDetailsText := c.fetchWordDetails(word, category)
 
 			// If word details are empty, the word is unknown
 			if wordDetailsText == "" {
 				// Track unknown words with their frequencies
 				lowerWord := strings.ToLower(word)
 				uniqueUnknownWords[lowerWord] += allWords[lowerWord]
+				filterTally.drop(lowerWord, "not in dictionary")
+				logger.Info("word processed", "word", lowerWord, "category", category, "outcome", "unknown")
+				if err := processed.Record(lowerWord, category, "unknown"); err != nil {
+					return fmt.Errorf("failed to write processed.jsonl file: %v", err)
+				}
 				continue
 			}
+			logger.Info("word processed", "word", strings.ToLower(word), "category", category, "outcome", "known")
+			if err := processed.Record(strings.ToLower(word), category, "known"); err != nil {
+				return fmt.Errorf("failed to write processed.jsonl file: %v", err)
+			}
 
 			// Word is known, add to regular output files
-			wordWriter.WriteString(capitalizePhrase(word) + "\n")
+			lowerWord := strings.ToLower(word)
+			knownWordCategories[lowerWord] = append(knownWordCategories[lowerWord], category)
+			// A family's forms are written together with its headword so a split never separates them
+			wordLine := c.formatWordLine(word, category, nil) + "\n"
+			if forms := wordFamilies[lowerWord]; len(forms) > 0 {
+				wordLine += formatFamilyLine(forms, allWords[lowerWord]) + "\n"
+			}
+			if err := wordWriter.WriteString(wordLine); err != nil {
+				return fmt.Errorf("failed to write output file for %s: %v", category, err)
+			}
 
 			// Only write to explanation files if the toggle is enabled
-			if config.GenerateExplanations {
-				if hasWrittenExplanations {
-					exWriter.WriteString("\n" + wordDetailsText)
-				} else {
-					exWriter.WriteString(wordDetailsText)
-					hasWrittenExplanations = true
+			if c.Config.GenerateExplanations {
+				if err := exWriter.WriteEntry(wordDetailsText); err != nil {
+					return fmt.Errorf("failed to write explanation file for %s: %v", category, err)
 				}
 			}
 
 			// Only write to example sentences files if the toggle is enabled
-			if config.GenerateExampleSentences {
-				exampleContent := generateExampleSentencesContent(word)
+			if c.Config.GenerateExampleSentences {
+				exampleContent := c.generateExampleSentencesContent(word, sourceExamples[strings.ToLower(word)], nil, c.Rand)
 				if exampleContent != "" {
-					if hasWrittenExamples {
-						esWriter.WriteString("\n" + exampleContent)
-					} else {
-						esWriter.WriteString(exampleContent)
-						hasWrittenExamples = true
+					if err := esWriter.WriteEntry(exampleContent); err != nil {
+						return fmt.Errorf("failed to write example sentences file for %s: %v", category, err)
+					}
+				}
+			}
+
+			// Only write to pronunciation files if the toggle is enabled
+			if c.Config.GeneratePronunciation {
+				if line, ok := c.formatPronunciationLine(word); ok {
+					if err := prWriter.WriteString(line + "\n"); err != nil {
+						return fmt.Errorf("failed to write pronunciation file for %s: %v", category, err)
 					}
 				}
 			}
 		}
 
-		wordWriter.Flush()
-		if config.GenerateExplanations {
-			exWriter.Flush()
+		if err := wordWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write output file for %s: %v", category, err)
+		}
+		if c.Config.GenerateExplanations {
+			if err := exWriter.Close(); err != nil {
+				return fmt.Errorf("failed to write explanation file for %s: %v", category, err)
+			}
+		}
+		if c.Config.GenerateExampleSentences {
+			if err := esWriter.Close(); err != nil {
+				return fmt.Errorf("failed to write example sentences file for %s: %v", category, err)
+			}
+		}
+		if c.Config.GeneratePronunciation {
+			if err := prWriter.Close(); err != nil {
+				return fmt.Errorf("failed to write pronunciation file for %s: %v", category, err)
+			}
+		}
+
+		logger.Info("category processed", "category", category, "words", len(sortedWords))
+		fmt.Fprintf(c.Out, "\n- Category '%s' processed: %d words\n", category, len(sortedWords))
+	}
+
+	if err := processed.Close(); err != nil {
+		return fmt.Errorf("failed to write processed.jsonl file: %v", err)
+	}
+
+	// Write recognized abbreviations with their expansions; they are not looked up in the dictionary
+	if c.Config.HandleAbbreviations {
+		abbreviationsWriter, err := c.newOutputWriter(filepath.Join(outputDir, baseFileName+"_Abbreviations.txt"))
+		if err != nil {
+			return fmt.Errorf("failed to create output file for Abbreviations: %v", err)
+		}
+		defer abbreviationsWriter.Close()
+
+		for _, key := range sortByFrequency(abbreviationCounts) {
+			abbreviation := abbreviations[key]
+			abbreviationsWriter.WriteString(fmt.Sprintf("%s (%s)\n", abbreviation.Display, abbreviation.Expansion))
+		}
+		if err := abbreviationsWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write output file for Abbreviations: %v", err)
+		}
+
+		logger.Info("category processed", "category", "Abbreviations", "words", len(abbreviationCounts))
+		fmt.Fprintf(c.Out, "\n- Category 'Abbreviations' processed: %d words\n", len(abbreviationCounts))
+	}
+
+	// Write likely proper nouns, most frequent first
+	if c.Config.SeparateProperNouns {
+		properNounsWriter, err := c.newOutputWriter(filepath.Join(outputDir, baseFileName+"_ProperNouns.txt"))
+		if err != nil {
+			return fmt.Errorf("failed to create output file for ProperNouns: %v", err)
+		}
+		defer properNounsWriter.Close()
+
+		for _, word := range sortByFrequency(properNounCounts) {
+			properNounsWriter.WriteString(capitalizePhrase(word) + "\n")
+		}
+		if err := properNounsWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write output file for ProperNouns: %v", err)
+		}
+
+		logger.Info("category processed", "category", "ProperNouns", "words", len(properNounCounts))
+		fmt.Fprintf(c.Out, "\n- Category 'ProperNouns' processed: %d words\n", len(properNounCounts))
+	}
+
+	// Write named entities grouped by label; they are not looked up in the dictionary
+	if c.Config.ExtractEntities {
+		entitiesWriter, err := c.newOutputWriter(filepath.Join(outputDir, "entities.txt"))
+		if err != nil {
+			return fmt.Errorf("failed to create entities.txt file: %v", err)
+		}
+		defer entitiesWriter.Close()
+
+		entities := doc.Entities()
+		if entitiesContent := generateEntitiesContent(entities); entitiesContent != "" {
+			entitiesWriter.WriteString(entitiesContent)
+		}
+		if err := entitiesWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write entities.txt file: %v", err)
+		}
+		logger.Info("output file complete", "file", "entities.txt", "entities", len(entities))
+		fmt.Fprintln(c.Out, "- entities.txt complete")
+	}
+
+	// Write words that received conflicting part-of-speech tags
+	if c.Config.FlagAmbiguousPOS {
+		ambiguousWriter, err := c.newOutputWriter(filepath.Join(outputDir, "ambiguous_words.txt"))
+		if err != nil {
+			return fmt.Errorf("failed to create ambiguous_words.txt file: %v", err)
+		}
+		defer ambiguousWriter.Close()
+
+		for _, line := range findAmbiguousWords(wordCategoryCounts) {
+			ambiguousWriter.WriteString(line + "\n")
+		}
+		if err := ambiguousWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write ambiguous_words.txt file: %v", err)
 		}
-		if config.GenerateExampleSentences {
-			esWriter.Flush()
+		logger.Info("output file complete", "file", "ambiguous_words.txt")
+		fmt.Fprintln(c.Out, "- ambiguous_words.txt complete")
+	}
+
+	// Write punctuation frequencies
+	if c.Config.CountPunctuation {
+		punctuationWriter, err := c.newOutputWriter(filepath.Join(outputDir, "punctuation_stats.txt"))
+		if err != nil {
+			return fmt.Errorf("failed to create punctuation_stats.txt file: %v", err)
 		}
+		defer punctuationWriter.Close()
 
-		log.Printf("\n- Category '%s' processed: %d words\n", category, len(sortedWords))
-		fmt.Printf("\n- Category '%s' processed: %d words\n", category, len(sortedWords))
+		for _, mark := range sortByFrequency(punctuationCounts) {
+			name, ok := punctuationNames[mark]
+			if !ok {
+				name = "Symbol"
+			}
+			punctuationWriter.WriteString(fmt.Sprintf("%s (%s): %d\n", name, mark, punctuationCounts[mark]))
+		}
+		if err := punctuationWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write punctuation_stats.txt file: %v", err)
+		}
+		logger.Info("output file complete", "file", "punctuation_stats.txt")
+		fmt.Fprintln(c.Out, "- punctuation_stats.txt complete")
 	}
 
 	// Sort unknown words by frequency in descending order
@@ -858,31 +2858,60 @@ func categorizeText(inputFile string) error {
 
 	// Sort by frequency (highest first)
 	sort.Slice(unknownWordsFreqList, func(i, j int) bool {
-		return unknownWordsFreqList[i].Count > unknownWordsFreqList[j].Count
+		if unknownWordsFreqList[i].Count != unknownWordsFreqList[j].Count {
+			return unknownWordsFreqList[i].Count > unknownWordsFreqList[j].Count
+		}
+		return unknownWordsFreqList[i].Word < unknownWordsFreqList[j].Word
 	})
 
 	// Create UnknownWords.txt file with deduplicated content sorted by frequency
-	unknownWordsFilePath := filepath.Join(outputDir, "UnknownWords.txt")
-	unknownWordsFile, err := os.Create(unknownWordsFilePath)
+	unknownWordsWriter, err := c.newOutputWriter(filepath.Join(outputDir, "UnknownWords.txt"))
 	if err != nil {
 		return fmt.Errorf("failed to create UnknownWords.txt file: %v", err)
 	}
-	defer unknownWordsFile.Close()
-	unknownWordsWriter := bufio.NewWriter(unknownWordsFile)
+	defer unknownWordsWriter.Close()
 
 	// Write unknown words sorted by frequency
 	for _, wordFreq := range unknownWordsFreqList {
 		unknownWordsWriter.WriteString(capitalizePhrase(wordFreq.Word) + "\n")
-		unknownWordsWriter.WriteString(capitalizePhrase(wordFreq.Word) + "\n")
 	}
 
 	// Flush the unknown words file
-	unknownWordsWriter.Flush()
-	log.Println("- UnknownWords.txt complete (deduplicated and sorted by frequency)")
-	fmt.Println("- UnknownWords.txt complete (deduplicated and sorted by frequency)")
+	if err := unknownWordsWriter.Close(); err != nil {
+		return fmt.Errorf("failed to write UnknownWords.txt file: %v", err)
+	}
+	logger.Info("output file complete", "file", "UnknownWords.txt", "words", len(unknownWordsFreqList))
+	fmt.Fprintln(c.Out, "- UnknownWords.txt complete (deduplicated and sorted by frequency)")
+
+	// Only create unknown_context.csv if the toggle is enabled
+	if c.Config.UnknownWordsContext {
+		var orderedUnknownWords []string
+		for _, wordFreq := range unknownWordsFreqList {
+			orderedUnknownWords = append(orderedUnknownWords, wordFreq.Word)
+		}
+		contextContent, err := generateUnknownContextCSV(orderedUnknownWords, allWords, sentences)
+		if err != nil {
+			return fmt.Errorf("failed to build unknown_context.csv: %v", err)
+		}
+
+		contextWriter, err := c.newOutputWriter(filepath.Join(outputDir, "unknown_context.csv"))
+		if err != nil {
+			return fmt.Errorf("failed to create unknown_context.csv file: %v", err)
+		}
+		defer contextWriter.Close()
+
+		if len(orderedUnknownWords) > 0 {
+			contextWriter.WriteString(contextContent)
+		}
+		if err := contextWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write unknown_context.csv file: %v", err)
+		}
+		logger.Info("output file complete", "file", "unknown_context.csv", "words", len(orderedUnknownWords))
+		fmt.Fprintln(c.Out, "- unknown_context.csv complete")
+	}
 
-	log.Println("\nGenerating final outputs...")
-	fmt.Println("\nGenerating final outputs...")
+	logger.Info("generating final outputs")
+	fmt.Fprintln(c.Out, "\nGenerating final outputs...")
 
 	// Track known and unknown words separately
 	var knownWords []string
@@ -890,151 +2919,359 @@ func categorizeText(inputFile string) error {
 	// Separate known and unknown words
 	for _, word := range sortedAllWords {
 		// Check if word is in our uniqueUnknownWords map
-		if _, isUnknown := uniqueUnknownWords[strings.ToLower(word)]; !isUnknown {
+		if _, isUnknown := uniqueUnknownWords[strings.ToLower(word)]; !isUnknown && !overflowSet[strings.ToLower(word)] {
 			knownWords = append(knownWords, word)
 		}
 	}
 
-	// Write `_AllWords.txt` file (always created, but only with known words)
-	allWordsFilePath := filepath.Join(outputDir, baseFileName+"_AllWords.txt")
-	allWordsFile, err := os.Create(allWordsFilePath)
+	// Write words that did not fit in the MaxOutputWords budget
+	if len(overflowWords) > 0 {
+		overflowWriter, err := c.newOutputWriter(filepath.Join(outputDir, baseFileName+"_Overflow.txt"))
+		if err != nil {
+			return fmt.Errorf("failed to create _Overflow.txt file: %v", err)
+		}
+		defer overflowWriter.Close()
+
+		for _, word := range overflowWords {
+			overflowWriter.WriteString(capitalizePhrase(word) + "\n")
+		}
+		if err := overflowWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write _Overflow.txt file: %v", err)
+		}
+		logger.Info("output file complete", "file", "Overflow.txt", "words", len(overflowWords), "budget", c.Config.MaxOutputWords)
+		fmt.Fprintf(c.Out, "- Overflow.txt complete (%d words beyond the budget of %d)\n", len(overflowWords), c.Config.MaxOutputWords)
+	}
+
+	// Download pronunciation audio for known words; cloze.tsv references the local files
+	var audioFiles map[string]string
+	if c.Config.DownloadAudio {
+		audioFiles = c.downloadAudioFiles(knownWords, filepath.Join(outputDir, "audio"), logger)
+		logger.Info("audio files available", "files", len(audioFiles))
+		fmt.Fprintf(c.Out, "- Audio files available: %d\n", len(audioFiles))
+	}
+
+	// Only create cloze.tsv if the toggle is enabled
+	if c.Config.GenerateCloze {
+		clozeWriter, err := c.newOutputWriter(filepath.Join(outputDir, "cloze.tsv"))
+		if err != nil {
+			return fmt.Errorf("failed to create cloze.tsv file: %v", err)
+		}
+		defer clozeWriter.Close()
+
+		if clozeContent := c.generateClozeContent(knownWords, sentences, audioFiles); clozeContent != "" {
+			clozeWriter.WriteString(clozeContent)
+		}
+		if err := clozeWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write cloze.tsv file: %v", err)
+		}
+		logger.Info("output file complete", "file", "cloze.tsv")
+		fmt.Fprintln(c.Out, "- cloze.tsv complete")
+	}
+
+	// Write `_AllWords.txt` file (only with known words)
+	allWordsWriter, err := c.newOutputWriter(filepath.Join(outputDir, baseFileName+"_AllWords.txt"))
 	if err != nil {
 		return fmt.Errorf("failed to create _AllWords.txt file: %v", err)
 	}
-	defer allWordsFile.Close()
+	defer allWordsWriter.Close()
+	allWordsWriter.SetMaxLines(c.Config.MaxLinesPerFile)
+
+	var rankStats map[string]RankStat
+	if c.Config.IncludeRankStats {
+		rankStats = computeRankStats(allWords)
+	}
 
-	allWordsWriter := bufio.NewWriter(allWordsFile)
 	for _, word := range knownWords {
-		allWordsWriter.WriteString(capitalizePhrase(word) + "\n")
+		wordLine := c.formatWordLine(word, "", rankStats) + "\n"
+		if forms := wordFamilies[strings.ToLower(word)]; len(forms) > 0 {
+			wordLine += formatFamilyLine(forms, allWords[strings.ToLower(word)]) + "\n"
+		}
+		allWordsWriter.WriteString(wordLine)
+	}
+	if err := allWordsWriter.Close(); err != nil {
+		return fmt.Errorf("failed to write _AllWords.txt file: %v", err)
 	}
-	allWordsWriter.Flush()
-	log.Println("- AllWords.txt complete")
-	fmt.Println("- AllWords.txt complete")
+	logger.Info("output file complete", "file", "AllWords.txt", "words", len(knownWords))
+	fmt.Fprintln(c.Out, "- AllWords.txt complete")
 
 	// Only create AllWords_ex.txt if the toggle is enabled
-	if config.GenerateExplanations {
+	if c.Config.GenerateExplanations {
 		// Write `_AllWords_ex.txt` file
-		allWordsExFilePath := filepath.Join(outputDir, baseFileName+"_AllWords_ex.txt")
-		allWordsExFile, err := os.Create(allWordsExFilePath)
+		allWordsExWriter, err := c.newOutputWriter(filepath.Join(outputDir, baseFileName+"_AllWords_ex.txt"))
 		if err != nil {
 			return fmt.Errorf("failed to create _AllWords_ex.txt file: %v", err)
 		}
-		defer allWordsExFile.Close()
-
-		allWordsExWriter := bufio.NewWriter(allWordsExFile)
-		hasWrittenAllWordsExplanations := false
+		defer allWordsExWriter.Close()
+		allWordsExWriter.SetMaxLines(c.Config.MaxLinesPerFile)
 
 		for i, word := range knownWords {
-			printProgress("Processing All Words explanations", word, i+1, len(knownWords))
-			wordDetailsText := fetchWordDetails(word)
+			c.printProgress("Processing All Words explanations", word, i+1, len(knownWords))
+			wordDetailsText := c.fetchWordDetails(word, "")
 			if wordDetailsText != "" {
-				if hasWrittenAllWordsExplanations {
-					allWordsExWriter.WriteString("\n" + wordDetailsText)
-				} else {
-					allWordsExWriter.WriteString(wordDetailsText)
-					hasWrittenAllWordsExplanations = true
-				}
+				allWordsExWriter.WriteEntry(wordDetailsText)
 			}
 		}
-		allWordsExWriter.Flush()
-		log.Println("\n- AllWords_ex.txt complete")
-		fmt.Println("\n- AllWords_ex.txt complete")
+		if err := allWordsExWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write _AllWords_ex.txt file: %v", err)
+		}
+		logger.Info("output file complete", "file", "AllWords_ex.txt")
+		fmt.Fprintln(c.Out, "\n- AllWords_ex.txt complete")
 	}
 
 	// Only create AllWords_es.txt if the toggle is enabled
-	if config.GenerateExampleSentences {
+	if c.Config.GenerateExampleSentences {
 		// Write `_AllWords_es.txt` file
-		allWordsEsFilePath := filepath.Join(outputDir, baseFileName+"_AllWords_es.txt")
-		allWordsEsFile, err := os.Create(allWordsEsFilePath)
+		allWordsEsWriter, err := c.newOutputWriter(filepath.Join(outputDir, baseFileName+"_AllWords_es.txt"))
 		if err != nil {
 			return fmt.Errorf("failed to create _AllWords_es.txt file: %v", err)
 		}
-		defer allWordsEsFile.Close()
+		defer allWordsEsWriter.Close()
+		allWordsEsWriter.SetMaxLines(c.Config.MaxLinesPerFile)
 
-		allWordsEsWriter := bufio.NewWriter(allWordsEsFile)
-		hasWrittenAllWordsExamples := false
+		// Example sentences already written, when deduplicating across words
+		var emittedExamples map[string]bool
+		if c.Config.DedupeExamplesGlobally {
+			emittedExamples = make(map[string]bool)
+		}
 
 		for i, word := range knownWords {
-			printProgress("Processing All Words example sentences", word, i+1, len(knownWords))
-			exampleContent := generateExampleSentencesContent(word)
+			c.printProgress("Processing All Words example sentences", word, i+1, len(knownWords))
+			exampleContent := c.generateExampleSentencesContent(word, sourceExamples[strings.ToLower(word)], emittedExamples, c.Rand)
 			if exampleContent != "" {
-				if hasWrittenAllWordsExamples {
-					allWordsEsWriter.WriteString("\n" + exampleContent)
-				} else {
-					allWordsEsWriter.WriteString(exampleContent)
-					hasWrittenAllWordsExamples = true
-				}
+				allWordsEsWriter.WriteEntry(exampleContent)
 			}
 		}
-		allWordsEsWriter.Flush()
-		log.Println("\n- AllWords_es.txt complete")
-		fmt.Println("\n- AllWords_es.txt complete")
+		if err := allWordsEsWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write _AllWords_es.txt file: %v", err)
+		}
+		logger.Info("output file complete", "file", "AllWords_es.txt")
+		fmt.Fprintln(c.Out, "\n- AllWords_es.txt complete")
 	}
 
-	// Report results
-	unknownCount := len(uniqueUnknownWords)
-	knownCount := len(knownWords)
+	// Only create the per-letter files if the toggle is enabled
+	if c.Config.GroupByFirstLetter {
+		lettersDir := filepath.Join(outputDir, "letters")
+		if c.outputSink == nil {
+			if err := os.MkdirAll(lettersDir, os.ModePerm); err != nil {
+				return fmt.Errorf("failed to create letters directory: %v", err)
+			}
+		}
 
-	log.Printf("\n===== Analysis Results =====\n")
-	log.Printf("Total unique words after deduplication: %d\n", totalUniqueWords)
-	log.Printf("Known words: %d, Unknown words: %d\n", knownCount, unknownCount)
-	log.Printf("Results written to directory: %s\n", outputDir)
-	if config.GenerateExplanations {
-		log.Printf("Word explanation files were generated.\n")
-	} else {
-		log.Printf("Word explanation files were not generated (disabled in config).\n")
+		groups := groupByFirstLetter(knownWords)
+		for group, groupWords := range groups {
+			letterWriter, err := c.newOutputWriter(filepath.Join(lettersDir, group+".txt"))
+			if err != nil {
+				return fmt.Errorf("failed to create %s.txt file: %v", group, err)
+			}
+			for _, word := range groupWords {
+				letterWriter.WriteString(capitalizePhrase(word) + "\n")
+			}
+			if err := letterWriter.Close(); err != nil {
+				return fmt.Errorf("failed to write %s.txt file: %v", group, err)
+			}
+		}
+		logger.Info("output file complete", "file", "letters", "groups", len(groups))
+		fmt.Fprintf(c.Out, "- Per-letter files complete (%d letters)\n", len(groups))
 	}
-	if config.GenerateExampleSentences {
-		log.Printf("Example sentences files were generated.\n")
-		if config.MaxExampleSentences > 0 {
-			log.Printf("Example sentences were limited to a maximum of %d per word.\n", config.MaxExampleSentences)
-		} else {
-			log.Printf("No limit was applied to the number of example sentences per word.\n")
+
+	// Only create AllWords_pronunciation.txt if the toggle is enabled
+	if c.Config.GeneratePronunciation {
+		allWordsPrWriter, err := c.newOutputWriter(filepath.Join(outputDir, baseFileName+"_AllWords_pronunciation.txt"))
+		if err != nil {
+			return fmt.Errorf("failed to create _AllWords_pronunciation.txt file: %v", err)
 		}
-	} else {
-		log.Printf("Example sentences files were not generated (disabled in config).\n")
+		defer allWordsPrWriter.Close()
+		allWordsPrWriter.SetMaxLines(c.Config.MaxLinesPerFile)
+
+		for _, word := range knownWords {
+			if line, ok := c.formatPronunciationLine(word); ok {
+				allWordsPrWriter.WriteString(line + "\n")
+			}
+		}
+		if err := allWordsPrWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write _AllWords_pronunciation.txt file: %v", err)
+		}
+		logger.Info("output file complete", "file", "AllWords_pronunciation.txt")
+		fmt.Fprintln(c.Out, "- AllWords_pronunciation.txt complete")
+	}
+
+	// Only create the PDF study sheet if the toggle is enabled
+	if c.Config.GeneratePDF && len(knownWords) > 0 {
+		pdfFilePath := filepath.Join(outputDir, baseFileName+"_StudySheet.pdf")
+		if err := c.writeStudySheetPDF(pdfFilePath, capitalizePhrase(baseFileName), knownWords); err != nil {
+			return fmt.Errorf("failed to write _StudySheet.pdf file: %v", err)
+		}
+		logger.Info("output file complete", "file", "StudySheet.pdf")
+		fmt.Fprintln(c.Out, "- StudySheet.pdf complete")
+	}
+
+	// Only create the synonym/antonym graph if the toggle is enabled
+	if c.Config.GenerateGraph {
+		nodes, edges := c.buildRelationGraph(knownWords)
+		graphFileName, graphContent := "graph.dot", formatGraphDOT(nodes, edges)
+		if c.Config.GraphFormat == "graphml" {
+			graphFileName, graphContent = "graph.graphml", formatGraphML(nodes, edges)
+		}
+		graphWriter, err := c.newOutputWriter(filepath.Join(outputDir, graphFileName))
+		if err != nil {
+			return fmt.Errorf("failed to create %s file: %v", graphFileName, err)
+		}
+		defer graphWriter.Close()
+
+		if len(edges) > 0 || !c.Config.SkipEmptyFiles {
+			graphWriter.WriteString(graphContent)
+		}
+		if err := graphWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write %s file: %v", graphFileName, err)
+		}
+		logger.Info("output file complete", "file", graphFileName, "nodes", len(nodes), "edges", len(edges))
+		fmt.Fprintf(c.Out, "- %s complete (%d words, %d relations)\n", graphFileName, len(nodes), len(edges))
+	}
+
+	// Only create glossary.txt if the toggle is enabled
+	if c.Config.GenerateGlossary {
+		glossaryWriter, err := c.newOutputWriter(filepath.Join(outputDir, "glossary.txt"))
+		if err != nil {
+			return fmt.Errorf("failed to create glossary.txt file: %v", err)
+		}
+		defer glossaryWriter.Close()
+
+		if glossaryContent := c.generateGlossaryContent(knownWordCategories); glossaryContent != "" {
+			glossaryWriter.WriteString(glossaryContent)
+		}
+		if err := glossaryWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write glossary.txt file: %v", err)
+		}
+		logger.Info("output file complete", "file", "glossary.txt")
+		fmt.Fprintln(c.Out, "- glossary.txt complete")
+	}
+
+	// Only create thesaurus.txt if the toggle is enabled
+	if c.Config.GenerateThesaurus {
+		thesaurusWriter, err := c.newOutputWriter(filepath.Join(outputDir, "thesaurus.txt"))
+		if err != nil {
+			return fmt.Errorf("failed to create thesaurus.txt file: %v", err)
+		}
+		defer thesaurusWriter.Close()
+
+		for _, word := range knownWords {
+			if line, ok := c.formatThesaurusLine(word); ok {
+				thesaurusWriter.WriteString(line + "\n")
+			}
+		}
+		if err := thesaurusWriter.Close(); err != nil {
+			return fmt.Errorf("failed to write thesaurus.txt file: %v", err)
+		}
+		logger.Info("output file complete", "file", "thesaurus.txt")
+		fmt.Fprintln(c.Out, "- thesaurus.txt complete")
 	}
 
-	fmt.Printf("\n===== Analysis Results =====\n")
-	fmt.Printf("Total unique words after deduplication: %d\n", totalUniqueWords)
-	fmt.Printf("Known words: %d, Unknown words: %d\n", knownCount, unknownCount)
-	fmt.Printf("Results written to directory: %s\n", outputDir)
-	if config.GenerateExplanations {
-		fmt.Printf("Word explanation files were generated.\n")
+	// Report results
+	unknownCount := len(uniqueUnknownWords)
+	knownCount := len(knownWords)
+	outputCount, removedCounts := filterTally.counts()
+
+	logger.Info("analysis results",
+		"totalUniqueWords", totalUniqueWords,
+		"rawUniqueWords", len(filterTally),
+		"outputUniqueWords", outputCount,
+		"removedByFilter", removedCounts,
+		"knownWords", knownCount,
+		"unknownWords", unknownCount,
+		"previousRunWords", len(previousWordCounts),
+		"outputDir", outputDir,
+		"explanations", c.Config.GenerateExplanations,
+		"exampleSentences", c.Config.GenerateExampleSentences,
+		"maxExampleSentences", c.Config.MaxExampleSentences)
+
+	fmt.Fprintf(c.Out, "\n===== Analysis Results =====\n")
+	fmt.Fprintf(c.Out, "Total unique words after deduplication (before dictionary lookups): %d\n", totalUniqueWords)
+	fmt.Fprintf(c.Out, "Unique words in input: %d, in output: %d\n", len(filterTally), outputCount)
+	for _, reason := range sortAlphabetically(mapKeys(removedCounts)) {
+		fmt.Fprintf(c.Out, "  Removed (%s): %d\n", reason, removedCounts[reason])
+	}
+	fmt.Fprintf(c.Out, "Known words: %d, Unknown words: %d (%.1f%% known)\n", knownCount, unknownCount, knownCoverage(knownCount, unknownCount)*100)
+	if c.Config.PreviousOutputsDir != "" {
+		fmt.Fprintf(c.Out, "Words skipped from previous runs: %d\n", len(previousWordCounts))
+	}
+	fmt.Fprintf(c.Out, "Results written to directory: %s\n", outputDir)
+	if c.Config.GenerateExplanations {
+		fmt.Fprintf(c.Out, "Word explanation files were generated.\n")
 	} else {
-		fmt.Printf("Word explanation files were not generated (disabled in config).\n")
+		fmt.Fprintf(c.Out, "Word explanation files were not generated (disabled in config).\n")
 	}
-	if config.GenerateExampleSentences {
-		fmt.Printf("Example sentences files were generated.\n")
-		if config.MaxExampleSentences > 0 {
-			fmt.Printf("Example sentences were limited to a maximum of %d per word.\n", config.MaxExampleSentences)
+	if c.Config.GenerateExampleSentences {
+		fmt.Fprintf(c.Out, "Example sentences files were generated.\n")
+		if c.Config.MaxExampleSentences > 0 {
+			fmt.Fprintf(c.Out, "Example sentences were limited to a maximum of %d per word.\n", c.Config.MaxExampleSentences)
 		} else {
-			fmt.Printf("No limit was applied to the number of example sentences per word.\n")
+			fmt.Fprintf(c.Out, "No limit was applied to the number of example sentences per word.\n")
 		}
 	} else {
-		fmt.Printf("Example sentences files were not generated (disabled in config).\n")
+		fmt.Fprintf(c.Out, "Example sentences files were not generated (disabled in config).\n")
 	}
-	log.Println("Text analysis complete.")
+	logger.Info("text analysis complete")
 
-	return nil
+	if knownCount == 0 && unknownCount > 0 {
+		return errAllWordsUnknown
+	}
+	return c.checkKnownCoverage(knownCount, unknownCount)
 }
 
-func main() {
-	// Initialize random number generator with current time as seed
-	rand.Seed(time.Now().UnixNano())
+// Process exit codes, so scripts can tell failures apart
+const (
+	exitOK              = 0
+	exitInputError      = 1 // No input file selected or the input cannot be used
+	exitProcessingError = 2 // Categorization failed, e.g. an output file could not be written
+	exitAllWordsUnknown = 3 // Processing finished but no word could be looked up (e.g. network down)
+	exitLowCoverage     = 4 // Processing finished but fewer words were known than MinKnownCoverage
+)
+
+// Errors returned by categorizeText that map to their own exit codes
+var (
+	errInputFile        = errors.New("cannot read input file")
+	errAllWordsUnknown  = errors.New("no word could be found in the dictionary")
+	errLowKnownCoverage = errors.New("known-word coverage below minimum")
+)
 
-	// Setup logging
-	setupLogging()
-	defer logFile.Close()
+// Maps an error from categorizeText to the process exit code
+func exitCodeForError(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errInputFile):
+		return exitInputError
+	case errors.Is(err, errAllWordsUnknown):
+		return exitAllWordsUnknown
+	case errors.Is(err, errLowKnownCoverage):
+		return exitLowCoverage
+	default:
+		return exitProcessingError
+	}
+}
 
-	log.Println("Application started")
+func main() {
+	os.Exit(run())
+}
 
+// Runs the application and returns the process exit code
+func run() int {
 	// Load configuration and proxy settings
-	config = loadConfig()
-	queryConfig = loadQueryConfig()
-	proxyConfig = loadProxyConfig()
-	loadWordCache()
-	loadWordUnknown()
+	config := loadConfig()
+	queryConfig := loadQueryConfig()
+	proxyConfig := loadProxyConfig()
+
+	// Setup logging once the configuration is known
+	logger, closeLogs := setupLogging(config.JSONLogFile)
+	defer closeLogs()
+
+	logger.Info("application started")
+
+	cache, cacheReadOnly := loadWordCache(defaultCachePath, logger)
+	cachePath := defaultCachePath
+	if cacheReadOnly {
+		cachePath = ""
+	}
 
 	// Load input configuration
 	inputConfig := loadInputConfig()
@@ -1046,42 +3283,68 @@ func main() {
 	if inputConfig.FilePath != "" {
 		// Check if the file exists and is readable
 		if _, err := os.Stat(inputConfig.FilePath); err == nil {
-			log.Println("Using configured input file:", inputConfig.FilePath)
+			logger.Info("using configured input file", "input", inputConfig.FilePath)
 			fmt.Println("Using configured input file:", inputConfig.FilePath)
 			inputFile = inputConfig.FilePath
 		} else {
-			log.Println("Configured input file not found or not accessible:", inputConfig.FilePath)
+			logger.Warn("configured input file not found or not accessible", "input", inputConfig.FilePath)
 			fmt.Println("Configured input file not found or not accessible:", inputConfig.FilePath)
-			log.Println("Falling back to file selection dialog")
+			logger.Info("falling back to file selection dialog")
 			fmt.Println("Falling back to file selection dialog")
 
 			// Fall back to GUI selection
 			inputFile, err = dialog.File().Title("Select Input File").Filter("Text Files (*.txt)", "txt").Load()
 			if err != nil || inputFile == "" {
-				log.Println("No file selected or error occurred.")
+				logger.Error("no file selected or error occurred", "error", err)
 				fmt.Println("No file selected or error occurred.")
-				return
+				return exitInputError
 			}
 		}
 	} else {
 		// No input file configured, use GUI selection as before
-		log.Println("Select the input text file:")
+		logger.Info("selecting the input text file")
 		fmt.Println("Select the input text file:")
 		inputFile, err = dialog.File().Title("Select Input File").Filter("Text Files (*.txt)", "txt").Load()
 		if err != nil || inputFile == "" {
-			log.Println("No file selected or error occurred.")
+			logger.Error("no file selected or error occurred", "error", err)
 			fmt.Println("No file selected or error occurred.")
-			return
+			return exitInputError
 		}
 	}
 
-	err = categorizeText(inputFile)
-	if err != nil {
-		log.Println("Error during categorization:", err)
-		fmt.Println("Error during categorization:", err)
-		return
+	deps := Dependencies{
+		Config:      config,
+		QueryConfig: queryConfig,
+		ProxyConfig: proxyConfig,
+		Provider:    newHTTPDictionaryProvider(queryConfig, proxyConfig),
+		Now:         time.Now,
+		Rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		Logger:      logger,
+		Cache:       cache,
+		Unknown:     loadWordUnknown(defaultUnknownPath),
+		WordLevels:  loadWordLevels(config.WordLevelsFile, logger),
+		Glossary:    loadGlossaryFile(config.GlossaryFile, logger),
+		CachePath:   cachePath,
+		UnknownPath: defaultUnknownPath,
+	}
+
+	return runCategorization(inputFile, deps)
+}
+
+// Categorizes the input file and returns the process exit code for the outcome
+func runCategorization(inputFile string, deps Dependencies) int {
+	out := deps.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	if err := categorizeText(inputFile, deps); err != nil {
+		deps.Logger.Error("error during categorization", "error", err)
+		fmt.Fprintln(out, "Error during categorization:", err)
+		return exitCodeForError(err)
 	}
 
-	log.Println("Text analysis complete.")
-	fmt.Println("Text analysis complete.")
+	deps.Logger.Info("text analysis complete")
+	fmt.Fprintln(out, "Text analysis complete.")
+	return exitOK
 }
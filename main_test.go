@@ -0,0 +1,1471 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jdkato/prose/v2"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata/golden")
+
+// The defaults from loadConfig, so tests don't depend on outputConfig.yml in the working directory
+func testConfig() OutputConfig {
+	return OutputConfig{
+		GenerateExplanations:     true,
+		GenerateExampleSentences: true,
+		SkipEmptyFiles:           true,
+		AudioDownloadWorkers:     4,
+		SortOrder:                "frequency",
+		SourceExampleStrategy:    "first",
+		OnDirCollision:           "error",
+		GraphFormat:              "dot",
+		SampleSeed:               1,
+		ExampleCountByLevel:      map[string]int{},
+		MinDefinitionWords:       3,
+	}
+}
+
+// Loads testdata/dictionary.json, the entries served by the fake dictionary
+func loadTestDictionary(t testing.TB) map[string]WordCache {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "dictionary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dictionary map[string]WordCache
+	if err := json.Unmarshal(data, &dictionary); err != nil {
+		t.Fatal(err)
+	}
+	return dictionary
+}
+
+// Dependencies for a reproducible run against the fake dictionary, writing into a temporary directory
+func testDependencies(t testing.TB, cfg OutputConfig) Dependencies {
+	t.Helper()
+	return Dependencies{
+		Config:      cfg,
+		QueryConfig: QueryConfig{DictionaryLanguage: "en"},
+		Provider:    mapDictionaryProvider(loadTestDictionary(t)),
+		OutputDir:   t.TempDir(),
+		Now:         func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) },
+		Rand:        rand.New(rand.NewSource(1)),
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Out:         io.Discard,
+	}
+}
+
+// Reads every file under dir, keyed by slash-separated relative path
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// Compares the files in dir with testdata/golden/<name>, or rewrites the golden copy with -update
+func assertGoldenDir(t *testing.T, dir string, name string) {
+	t.Helper()
+	goldenDir := filepath.Join("testdata", "golden", name)
+	got := readTree(t, dir)
+
+	if *update {
+		if err := os.RemoveAll(goldenDir); err != nil {
+			t.Fatal(err)
+		}
+		for rel, content := range got {
+			path := filepath.Join(goldenDir, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return
+	}
+
+	want := readTree(t, goldenDir)
+	for rel, content := range want {
+		if got[rel] != content {
+			t.Errorf("%s differs from golden copy:\ngot:\n%s\nwant:\n%s", rel, got[rel], content)
+		}
+	}
+	for rel := range got {
+		if _, ok := want[rel]; !ok {
+			t.Errorf("unexpected output file %s", rel)
+		}
+	}
+}
+
+func TestCategorizeTextGolden(t *testing.T) {
+	deps := testDependencies(t, testConfig())
+	if err := categorizeText(filepath.Join("testdata", "sample.txt"), deps); err != nil {
+		t.Fatal(err)
+	}
+	assertGoldenDir(t, deps.OutputDir, "sample")
+}
+
+func TestCategorizeTextUpdatesInjectedCache(t *testing.T) {
+	deps := testDependencies(t, testConfig())
+	deps.Cache = make(map[string]WordCache)
+	deps.Unknown = make(map[string]bool)
+	if err := categorizeText(filepath.Join("testdata", "sample.txt"), deps); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := deps.Cache["fox"]; !ok {
+		t.Error("looked-up word missing from the injected cache")
+	}
+	if !deps.Unknown["because"] {
+		t.Error("missed word missing from the injected unknown list")
+	}
+	for _, name := range []string{defaultCachePath, defaultUnknownPath} {
+		if _, err := os.Stat(name); err == nil {
+			t.Errorf("%s written to the working directory without a CachePath/UnknownPath", name)
+		}
+	}
+}
+
+func TestSkipEmptyFilesOmitsEmptyCategory(t *testing.T) {
+	// None of the sample's verbs are in the dictionary, so the Verbs outputs would be empty
+	verbFiles := []string{"sample_Verbs.txt", "sample_Verbs_ex.txt", "sample_Verbs_es.txt"}
+
+	deps := testDependencies(t, testConfig())
+	if err := categorizeText(filepath.Join("testdata", "sample.txt"), deps); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range verbFiles {
+		if _, err := os.Stat(filepath.Join(deps.OutputDir, name)); err == nil {
+			t.Errorf("%s created for an empty category", name)
+		}
+	}
+
+	cfg := testConfig()
+	cfg.SkipEmptyFiles = false
+	deps = testDependencies(t, cfg)
+	if err := categorizeText(filepath.Join("testdata", "sample.txt"), deps); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range verbFiles {
+		info, err := os.Stat(filepath.Join(deps.OutputDir, name))
+		if err != nil {
+			t.Errorf("%s not created with SkipEmptyFiles off: %v", name, err)
+		} else if info.Size() != 0 {
+			t.Errorf("%s has %d bytes, want an empty file", name, info.Size())
+		}
+	}
+}
+
+func TestGenerateGlossaryContentGolden(t *testing.T) {
+	c := newClassifier(testDependencies(t, testConfig()))
+	c.Cache = loadTestDictionary(t)
+
+	got := c.generateGlossaryContent(map[string][]string{
+		"quick":   {"Adjectives"},
+		"dog":     {"Verbs", "Nouns"},
+		"field":   {"Nouns"},
+		"happy":   {"Adjectives"},
+		"unknown": {"Nouns"}, // Not in the cache, so left out
+	})
+
+	goldenPath := filepath.Join("testdata", "golden", "glossary.txt")
+	if *update {
+		if err := os.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("glossary differs from golden copy:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRunCategorizationExitCodes(t *testing.T) {
+	sample := filepath.Join("testdata", "sample.txt")
+
+	tests := []struct {
+		name      string
+		inputFile string
+		setup     func(t *testing.T, deps *Dependencies)
+		want      int
+	}{
+		{name: "success", inputFile: sample, want: exitOK},
+		{name: "missing input", inputFile: filepath.Join("testdata", "missing.txt"), want: exitInputError},
+		{
+			name:      "output directory blocked by a file",
+			inputFile: sample,
+			setup: func(t *testing.T, deps *Dependencies) {
+				blocker := filepath.Join(deps.OutputDir, "blocker")
+				if err := os.WriteFile(blocker, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				deps.OutputDir = filepath.Join(blocker, "out")
+			},
+			want: exitProcessingError,
+		},
+		{
+			name:      "all words unknown",
+			inputFile: sample,
+			setup: func(t *testing.T, deps *Dependencies) {
+				deps.Provider = mapDictionaryProvider{}
+			},
+			want: exitAllWordsUnknown,
+		},
+		{
+			name:      "low coverage",
+			inputFile: sample,
+			setup: func(t *testing.T, deps *Dependencies) {
+				deps.Config.MinKnownCoverage = 0.9
+			},
+			want: exitLowCoverage,
+		},
+		{
+			name:      "coverage above the minimum",
+			inputFile: filepath.Join("testdata", "mostly_known.txt"),
+			setup: func(t *testing.T, deps *Dependencies) {
+				deps.Config.MinKnownCoverage = 0.8
+			},
+			want: exitOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := testDependencies(t, testConfig())
+			if tt.setup != nil {
+				tt.setup(t, &deps)
+			}
+			if got := runCategorization(tt.inputFile, deps); got != tt.want {
+				t.Errorf("runCategorization = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadWordCacheMigratesV1(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cache, readOnly := loadWordCache(filepath.Join("testdata", "word_cache_v1.json"), logger)
+	if readOnly {
+		t.Fatal("v1 cache loaded read-only")
+	}
+
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{word: "fox", want: []string{"The fox crept into the henhouse."}},
+		{word: "field", want: []string{}},
+		// Unversioned caches written after examples became a slice keep all of them
+		{word: "dog", want: []string{"The dog barked at the postman.", "She walks her dog every morning."}},
+	}
+	for _, tt := range tests {
+		entry, ok := cache[tt.word]
+		if !ok || len(entry.Definitions) != 1 {
+			t.Errorf("%s: entry not migrated: %+v", tt.word, entry)
+			continue
+		}
+		if got := entry.Definitions[0].Examples; strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: examples = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+	if got := cache["fox"].Phonetic; got != "/fɒks/" {
+		t.Errorf("fox phonetic = %q, want it kept", got)
+	}
+
+	// Saving writes the current version, which loads back unchanged
+	path := filepath.Join(t.TempDir(), "word_cache.json")
+	c := newClassifier(Dependencies{Cache: cache, CachePath: path})
+	c.saveWordCache()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), fmt.Sprintf(`"version": %d`, wordCacheVersion)) {
+		t.Errorf("saved cache has no version %d:\n%s", wordCacheVersion, data)
+	}
+	reloaded, _ := loadWordCache(path, logger)
+	if got := reloaded["dog"].Definitions[0].Examples; len(got) != 2 {
+		t.Errorf("reloaded dog examples = %q, want 2", got)
+	}
+}
+
+func TestLoadWordCacheFromNewerVersionIsReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "word_cache.json")
+	data := fmt.Sprintf(`{"version": %d, "words": {}}`, wordCacheVersion+1)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, readOnly := loadWordCache(path, slog.New(slog.NewTextHandler(io.Discard, nil))); !readOnly {
+		t.Error("cache from a newer version not marked read-only")
+	}
+}
+
+func TestCreateHTTPClientAllowedHosts(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := createHTTPClient(QueryConfig{AllowedHosts: []string{"127.0.0.1"}}, ProxyConfig{})
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request to allowed host failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// Same server, but reached through a host name that isn't on the list
+	blockedURL := "http://localhost:" + serverURL.Port()
+	if _, err := client.Get(blockedURL); err == nil || !strings.Contains(err.Error(), "not in allowedHosts") {
+		t.Errorf("request to disallowed host: err = %v, want it blocked", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("server saw %d requests, want only the allowed one", got)
+	}
+}
+
+func TestTrimTokenPunctuation(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{token: "word,", want: "word"},
+		{token: "(hello)", want: "hello"},
+		{token: "co-op.", want: "co-op"},
+		{token: "don't", want: "don't"},
+		{token: "\"quoted\"", want: "quoted"},
+		{token: "...", want: ""},
+		// Clitics split off by the tokenizer are not words
+		{token: "'s", want: ""},
+		{token: "'re", want: ""},
+		{token: "'ll", want: ""},
+		{token: "’ve", want: ""},
+		{token: "'tis", want: "tis"},
+	}
+	for _, tt := range tests {
+		if got := trimTokenPunctuation(tt.token); got != tt.want {
+			t.Errorf("trimTokenPunctuation(%q) = %q, want %q", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestSenseCountMatchesCategoryFilter(t *testing.T) {
+	cfg := testConfig()
+	cfg.IncludeSenseCount = true
+	cfg.FilterDefinitionsByCategory = true
+	c := newClassifier(testDependencies(t, cfg))
+	c.Cache = loadTestDictionary(t)
+
+	tests := []struct {
+		category string
+		want     string
+	}{
+		{category: "Nouns", want: "Dog (1)"},
+		{category: "Verbs", want: "Dog (1)"},
+		{category: "", want: "Dog (2)"},
+	}
+	for _, tt := range tests {
+		if got := c.formatWordLine("dog", tt.category, nil); got != tt.want {
+			t.Errorf("formatWordLine(dog, %q) = %q, want %q", tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownContextCountsWordInSeveralCategoriesOnce(t *testing.T) {
+	// "well" is tagged as an adverb and as a noun, and isn't in the dictionary
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("The field is green. He sings well. The well is deep."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.UnknownWordsContext = true
+	deps := testDependencies(t, cfg)
+	if err := categorizeText(input, deps); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(deps.OutputDir, "unknown_context.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "well,2,He sings well.\n"
+	if !strings.Contains(string(data), want) {
+		t.Errorf("unknown_context.csv has no row %q:\n%s", want, data)
+	}
+}
+
+func TestOutputWriterSplitsEntriesByLines(t *testing.T) {
+	c := newClassifier(Dependencies{Config: testConfig()})
+	c.outputSink = make(map[string]*bytes.Buffer)
+	writer, err := c.newOutputWriter("words_ex.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.SetMaxLines(4)
+	for _, entry := range []string{"Fox\n\tnoun", "Dog\n\tnoun", "Run\n\tverb"} {
+		if err := writer.WriteEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two two-line entries fill a four-line part exactly
+	want := map[string]string{
+		"words_ex_part1.txt": "Fox\n\tnoun\nDog\n\tnoun",
+		"words_ex_part2.txt": "Run\n\tverb",
+	}
+	if len(c.outputSink) != len(want) {
+		t.Errorf("got %d files, want %d", len(c.outputSink), len(want))
+	}
+	for path, content := range want {
+		buffer, ok := c.outputSink[path]
+		if !ok {
+			t.Errorf("%s not written", path)
+			continue
+		}
+		if got := buffer.String(); got != content {
+			t.Errorf("%s = %q, want %q", path, got, content)
+		}
+	}
+}
+
+func TestOutputWriterHeadersOnceUnderConcurrentWrites(t *testing.T) {
+	categories := categoryOrder
+	c := newClassifier(Dependencies{Config: testConfig()})
+	c.outputSink = make(map[string]*bytes.Buffer)
+
+	writers := make([]*outputWriter, len(categories))
+	for i, category := range categories {
+		writer, err := c.newOutputWriter(category + ".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.SetHeader("== " + category + " ==")
+		writers[i] = writer
+	}
+
+	// Several workers write to every category at once
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				for _, writer := range writers {
+					writer.WriteEntry(fmt.Sprintf("Word %d-%d\n\tdefinition", worker, i))
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	for i, writer := range writers {
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		content := c.outputSink[categories[i]+".txt"].String()
+		header := "== " + categories[i] + " =="
+		if !strings.HasPrefix(content, header+"\n") {
+			t.Errorf("%s does not start with its header: %.40q", categories[i], content)
+		}
+		if n := strings.Count(content, "== "); n != 1 {
+			t.Errorf("%s has %d headers, want 1", categories[i], n)
+		}
+		if n := strings.Count(content, "Word "); n != 8*25 {
+			t.Errorf("%s has %d entries, want %d", categories[i], n, 8*25)
+		}
+	}
+}
+
+func TestCategoriesProcessedInFixedOrder(t *testing.T) {
+	cfg := testConfig()
+	cfg.CategoryHeaders = true
+	cfg.SkipEmptyFiles = false
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("The quick fox runs quickly across the green field. They ran home."), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 0; run < 3; run++ {
+		var logs bytes.Buffer
+		deps := testDependencies(t, cfg)
+		deps.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+		if err := categorizeText(input, deps); err != nil {
+			t.Fatal(err)
+		}
+
+		// The categories of the words in the order they were processed, collapsing runs
+		var order []string
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var record struct {
+				Msg      string `json:"msg"`
+				Category string `json:"category"`
+			}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatal(err)
+			}
+			if record.Msg == "word processed" && (len(order) == 0 || order[len(order)-1] != record.Category) {
+				order = append(order, record.Category)
+			}
+		}
+		if !slices.Equal(order, categoryOrder) {
+			t.Fatalf("run %d processed categories in order %v, want %v", run, order, categoryOrder)
+		}
+
+		for _, category := range categoryOrder {
+			content := readOutput(t, deps.OutputDir, "input_"+category+".txt")
+			if header := "== " + category + " ==\n"; content != "" && !strings.HasPrefix(content, header) {
+				t.Errorf("input_%s.txt does not start with %q: %.40q", category, header, content)
+			}
+		}
+	}
+}
+
+func TestCategorizeInMemoryMatchesDiskRun(t *testing.T) {
+	text, err := os.ReadFile(filepath.Join("testdata", "sample.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := loadTestDictionary(t)
+	outputs, err := categorizeInMemory(string(text), cache, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := readTree(t, filepath.Join("testdata", "golden", "sample"))
+	if len(outputs) != len(want) {
+		t.Errorf("got %d outputs, want %d", len(outputs), len(want))
+	}
+	for rel, content := range want {
+		path := filepath.Join("output", strings.Replace(rel, "sample", "input", 1))
+		if outputs[path] != content {
+			t.Errorf("%s differs from golden %s", path, rel)
+		}
+	}
+
+	if !reflect.DeepEqual(cache, loadTestDictionary(t)) {
+		t.Error("categorizeInMemory modified the caller's cache")
+	}
+}
+
+func BenchmarkCategorizeInMemory(b *testing.B) {
+	text, err := os.ReadFile(filepath.Join("testdata", "sample.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	// A few hundred sentences, so classification and formatting dominate setup
+	input := strings.Repeat(string(text), 50)
+	cache := loadTestDictionary(b)
+	cfg := testConfig()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := categorizeInMemory(input, cache, cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExtractEntitiesGroupsByLabelWithoutLookups(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.txt")
+	text := "Barack Obama visited Paris with his dog. Barack Obama said the dog was happy in Paris."
+	if err := os.WriteFile(input, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.ExtractEntities = true
+	deps := testDependencies(t, cfg)
+
+	var lookups []string
+	dictionary := mapDictionaryProvider(loadTestDictionary(t))
+	deps.Provider = lookupRecorder{provider: dictionary, words: &lookups}
+	if err := categorizeText(input, deps); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(deps.OutputDir, "entities.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "GPE\n\tParis (2)\nPERSON\n\tBarack Obama (2)\n"
+	if string(data) != want {
+		t.Errorf("entities.txt = %q, want %q", data, want)
+	}
+
+	for _, word := range lookups {
+		if word == "barack" || word == "obama" || word == "paris" {
+			t.Errorf("entity word %q was looked up", word)
+		}
+	}
+	if !slices.Contains(lookups, "dog") {
+		t.Error("ordinary words were not looked up")
+	}
+}
+
+// Records the words looked up through a provider
+type lookupRecorder struct {
+	provider DictionaryProvider
+	words    *[]string
+}
+
+func (r lookupRecorder) Lookup(word string, language string) (WordCache, error) {
+	*r.words = append(*r.words, wordKey(word))
+	return r.provider.Lookup(word, language)
+}
+
+func TestSenseCountSkipsLowQualityDefinitions(t *testing.T) {
+	cfg := testConfig()
+	cfg.IncludeSenseCount = true
+	cfg.FilterLowQualityDefinitions = true
+	c := newClassifier(testDependencies(t, cfg))
+	c.Cache["saw"] = WordCache{Definitions: []Definition{
+		{PartOfSpeech: "noun", Definition: "A tool for cutting wood with a toothed blade."},
+		{PartOfSpeech: "verb", Definition: "Simple past of see."},
+		{PartOfSpeech: "noun", Definition: "A saying."},
+	}}
+
+	if got, want := c.formatWordLine("saw", "", nil), "Saw (1)"; got != want {
+		t.Errorf("formatWordLine = %q, want %q", got, want)
+	}
+	details := c.fetchWordDetails("saw", "")
+	if n := strings.Count(details, ", noun: ") + strings.Count(details, ", verb: "); n != 1 {
+		t.Errorf("fetchWordDetails lists %d senses, want the 1 counted:\n%s", n, details)
+	}
+}
+
+// Runs the pipeline on text against the fake dictionary and returns the output directory
+func categorizeTestText(t *testing.T, cfg OutputConfig, text string) string {
+	t.Helper()
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deps := testDependencies(t, cfg)
+	if err := categorizeText(input, deps); err != nil {
+		t.Fatal(err)
+	}
+	return deps.OutputDir
+}
+
+// Reads one output file, failing the test if it is missing
+func readOutput(t *testing.T, dir string, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHandleAbbreviations(t *testing.T) {
+	cfg := testConfig()
+	cfg.HandleAbbreviations = true
+	dir := categorizeTestText(t, cfg, "The dog ran across the field, e.g. a quick fox did too. The happy dog lives in the U.S. now.")
+
+	want := "e.g. (for example)\nU.S. (United States)\n"
+	if got := readOutput(t, dir, "input_Abbreviations.txt"); got != want {
+		t.Errorf("_Abbreviations.txt = %q, want %q", got, want)
+	}
+	if unknown := readOutput(t, dir, "UnknownWords.txt"); strings.Contains(strings.ToLower(unknown), "e.g") {
+		t.Errorf("abbreviation treated as an unknown word:\n%s", unknown)
+	}
+}
+
+func TestCountPunctuation(t *testing.T) {
+	cfg := testConfig()
+	cfg.CountPunctuation = true
+	dir := categorizeTestText(t, cfg, "The dog, the cat, and the fox ran; the field was green.")
+
+	want := "Comma (,): 2\nPeriod (.): 1\nSemicolon (;): 1\n"
+	if got := readOutput(t, dir, "punctuation_stats.txt"); got != want {
+		t.Errorf("punctuation_stats.txt = %q, want %q", got, want)
+	}
+}
+
+func TestExampleMaxLevelExcludesHarderExamples(t *testing.T) {
+	cfg := testConfig()
+	cfg.ExampleMaxLevel = "A2"
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("The dog ran across the field."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deps := testDependencies(t, cfg)
+	deps.WordLevels = map[string]string{"postman": "C2", "morning": "A1"}
+	if err := categorizeText(input, deps); err != nil {
+		t.Fatal(err)
+	}
+
+	examples := readOutput(t, deps.OutputDir, "input_Nouns_es.txt")
+	if strings.Contains(examples, "postman") {
+		t.Errorf("example with a C2 word kept at ExampleMaxLevel A2:\n%s", examples)
+	}
+	if !strings.Contains(examples, "She walks her dog every morning.") {
+		t.Errorf("example within ExampleMaxLevel dropped:\n%s", examples)
+	}
+}
+
+func TestIsStopWordKeepsContentSenses(t *testing.T) {
+	tests := []struct {
+		word string
+		tag  string
+		want bool
+	}{
+		{"can", "NN", false},
+		{"can", "MD", true},
+		{"Can", "MD", true},
+		{"can", "VB", false},
+		{"the", "DT", true},
+		{"is", "VBZ", true},
+		{"dog", "NN", false},
+	}
+	for _, tt := range tests {
+		if got := isStopWord(tt.word, tt.tag); got != tt.want {
+			t.Errorf("isStopWord(%q, %q) = %v, want %v", tt.word, tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestSortByDifficulty(t *testing.T) {
+	c := newClassifier(Dependencies{WordLevels: map[string]string{
+		"cat":     "A1",
+		"dog":     "A1",
+		"fox":     "B2",
+		"zealous": "C2",
+	}})
+	counts := map[string]int{
+		"zealous": 9,
+		"fox":     1,
+		"dog":     2,
+		"cat":     2,
+		"Bird":    5,
+		"ant":     5,
+		"eel":     1,
+	}
+
+	want := []string{"cat", "dog", "fox", "zealous", "Bird", "ant", "eel"}
+	for i := 0; i < 5; i++ {
+		if got := c.sortByDifficulty(counts); !slices.Equal(got, want) {
+			t.Fatalf("sortByDifficulty = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMaxOutputWordsWritesTopWordsAndOverflow(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxOutputWords = 3
+	input, err := os.ReadFile(filepath.Join("testdata", "sample.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := categorizeTestText(t, cfg, string(input))
+
+	if got, want := readOutput(t, dir, "input_AllWords.txt"), "Fox\nDog\nField\n"; got != want {
+		t.Errorf("_AllWords.txt = %q, want %q", got, want)
+	}
+	want := "Green\nHappy\nQuick\nRuns\nA\nAcross\nAfter\nAnd\nBecause\n"
+	if got := readOutput(t, dir, "input_Overflow.txt"); got != want {
+		t.Errorf("_Overflow.txt = %q, want %q", got, want)
+	}
+}
+
+func TestFlagAmbiguousPOSListsNounAndVerb(t *testing.T) {
+	cfg := testConfig()
+	cfg.FlagAmbiguousPOS = true
+	dir := categorizeTestText(t, cfg, "I like to run. The run was long.")
+
+	want := "Run (ambiguous POS): Nouns 1, Verbs 1\n"
+	if got := readOutput(t, dir, "ambiguous_words.txt"); got != want {
+		t.Errorf("ambiguous_words.txt = %q, want %q", got, want)
+	}
+}
+
+func TestClozeSentence(t *testing.T) {
+	tests := []struct {
+		sentence string
+		word     string
+		want     string
+	}{
+		{"The fox ran.", "fox", "The {{c1::fox}} ran."},
+		{"Fox after fox, the fox ran.", "fox", "{{c1::Fox}} after {{c2::fox}}, the {{c3::fox}} ran."},
+		{"The foxes ran.", "fox", "The foxes ran."},
+	}
+	for _, tt := range tests {
+		if got := clozeSentence(tt.sentence, tt.word); got != tt.want {
+			t.Errorf("clozeSentence(%q, %q) = %q, want %q", tt.sentence, tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestPreviousOutputsDirExcludesKnownWords(t *testing.T) {
+	previous := t.TempDir()
+	if err := os.WriteFile(filepath.Join(previous, "old_AllWords.txt"), []byte("Water\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.PreviousOutputsDir = previous
+	dir := categorizeTestText(t, cfg, "The water is cold. The dog is happy.")
+
+	files := readTree(t, dir)
+	for name, content := range files {
+		if strings.Contains(strings.ToLower(content), "water") {
+			t.Errorf("%s lists a word from a previous run:\n%s", name, content)
+		}
+	}
+	if !strings.Contains(files["input_AllWords.txt"], "Dog") {
+		t.Errorf("_AllWords.txt is missing a new word:\n%s", files["input_AllWords.txt"])
+	}
+}
+
+func TestSplitSlashSeparatedWordsDropsEmptyParts(t *testing.T) {
+	tests := map[string][]string{
+		"cat//dog/": {"cat", "dog"},
+		"/cat/":     {"cat"},
+		"cat / dog": {"cat", "dog"},
+		"cat":       {"cat"},
+		"//":        nil,
+	}
+	for text, want := range tests {
+		if got := splitSlashSeparatedWords(text); !slices.Equal(got, want) {
+			t.Errorf("splitSlashSeparatedWords(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestComputeRankStats(t *testing.T) {
+	counts := map[string]int{"the": 5, "dog": 2, "cat": 2, "fox": 1}
+
+	want := map[string]RankStat{
+		"the": {Rank: 1, Coverage: 50},
+		"cat": {Rank: 2, Coverage: 70},
+		"dog": {Rank: 3, Coverage: 90},
+		"fox": {Rank: 4, Coverage: 100},
+	}
+	if got := computeRankStats(counts); !reflect.DeepEqual(got, want) {
+		t.Errorf("computeRankStats = %v, want %v", got, want)
+	}
+}
+
+// Serves a separate set of entries per dictionary language
+type languageDictionaryProvider map[string]mapDictionaryProvider
+
+func (p languageDictionaryProvider) Lookup(word string, language string) (WordCache, error) {
+	entries, ok := p[language]
+	if !ok {
+		return WordCache{}, errWordNotFound
+	}
+	return entries.Lookup(word, language)
+}
+
+func TestQueryDictionaryAPIUsesFallbackLanguage(t *testing.T) {
+	chien := WordCache{Definitions: []Definition{{PartOfSpeech: "noun", Definition: "Animal domestique de la famille des canidés."}}}
+	c := newClassifier(Dependencies{
+		Config:      testConfig(),
+		QueryConfig: QueryConfig{DictionaryLanguage: "en", FallbackLanguages: []string{"fr"}},
+		Provider: languageDictionaryProvider{
+			"en": {"dog": loadTestDictionary(t)["dog"]},
+			"fr": {"chien": chien},
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	if !c.queryDictionaryAPI("chien") {
+		t.Fatal("word only in the fallback dictionary was not found")
+	}
+	if got := c.Cache["chien"].Language; got != "fr" {
+		t.Errorf("cached Language = %q, want %q", got, "fr")
+	}
+	if details := c.fetchWordDetails("chien", ""); !strings.Contains(details, "\tLanguage: fr\n") {
+		t.Errorf("details do not record the fallback language:\n%s", details)
+	}
+
+	if !c.queryDictionaryAPI("dog") {
+		t.Fatal("word in the primary dictionary was not found")
+	}
+	if got := c.Cache["dog"].Language; got != "en" {
+		t.Errorf("cached Language = %q, want %q", got, "en")
+	}
+}
+
+func TestWordKeyNormalizesWhitespace(t *testing.T) {
+	for _, phrase := range []string{"New  York", "new\tyork", " New York "} {
+		if got := wordKey(phrase); got != wordKey("New York") {
+			t.Errorf("wordKey(%q) = %q, want %q", phrase, got, wordKey("New York"))
+		}
+		if got := capitalizePhrase(phrase); got != "New York" {
+			t.Errorf("capitalizePhrase(%q) = %q, want %q", phrase, got, "New York")
+		}
+	}
+}
+
+func TestWordProcessedLogRecords(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("The dog sleeps in the meadow."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	deps := testDependencies(t, testConfig())
+	deps.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	if err := categorizeText(input, deps); err != nil {
+		t.Fatal(err)
+	}
+
+	outcomes := make(map[string]map[string]any)
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("malformed log line %q: %v", line, err)
+		}
+		if record["msg"] == "word processed" {
+			outcomes[record["word"].(string)] = record
+		}
+	}
+	for word, want := range map[string][2]string{
+		"dog":    {"Nouns", "known"},
+		"meadow": {"Nouns", "unknown"},
+	} {
+		record, ok := outcomes[word]
+		if !ok {
+			t.Errorf("no word processed record for %q", word)
+			continue
+		}
+		if record["category"] != want[0] || record["outcome"] != want[1] {
+			t.Errorf("record for %q has category %v and outcome %v, want %s and %s", word, record["category"], record["outcome"], want[0], want[1])
+		}
+	}
+}
+
+func TestRawModeKeepsWordsVerbatimWithoutProse(t *testing.T) {
+	original := newDocument
+	t.Cleanup(func() { newDocument = original })
+	newDocument = func(text string, opts ...prose.DocOpt) (*prose.Document, error) {
+		t.Error("RawMode ran the prose pipeline")
+		return original(text, opts...)
+	}
+
+	cfg := testConfig()
+	cfg.RawMode = true
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("iPhone\nNew York\ndog\nDNA-seq\niPhone\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deps := testDependencies(t, cfg)
+	dictionary := loadTestDictionary(t)
+	dictionary["iphone"] = dictionary["dog"]
+	dictionary["new york"] = dictionary["field"]
+	deps.Provider = mapDictionaryProvider(dictionary)
+	if err := categorizeText(input, deps); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readOutput(t, deps.OutputDir, "input_AllWords.txt"), "iPhone\nNew York\ndog\n"; got != want {
+		t.Errorf("_AllWords.txt = %q, want %q", got, want)
+	}
+	if got, want := readOutput(t, deps.OutputDir, "UnknownWords.txt"), "DNA-seq\n"; got != want {
+		t.Errorf("UnknownWords.txt = %q, want %q", got, want)
+	}
+}
+
+func TestHighlightWord(t *testing.T) {
+	tests := []struct {
+		sentence string
+		style    string
+		want     string
+	}{
+		{"the top spun", "asterisks", "the *top* spun"},
+		{"Top, the top spun.", "asterisks", "*Top*, the *top* spun."},
+		{"the stop spun", "asterisks", "the stop spun"},
+		{"the top spun", "uppercase", "the TOP spun"},
+		{"the top spun", "none", "the top spun"},
+	}
+	for _, tt := range tests {
+		if got := highlightWord(tt.sentence, "top", tt.style); got != tt.want {
+			t.Errorf("highlightWord(%q, %q, %q) = %q, want %q", tt.sentence, "top", tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestRankSourceExamplesCoveragePrefersRicherSentences(t *testing.T) {
+	counts := map[string]int{"fox": 3, "dog": 2, "field": 2, "green": 1}
+	sentences := []string{
+		"The fox slept.",
+		"The fox and the dog ran across the green field.",
+		"A fox ran past the dog.",
+	}
+
+	ranked := rankSourceExamples(counts, sentences, "coverage")
+	want := []string{sentences[1], sentences[2], sentences[0]}
+	if !slices.Equal(ranked["fox"], want) {
+		t.Errorf("coverage ranking for fox = %q, want %q", ranked["fox"], want)
+	}
+
+	ranked = rankSourceExamples(counts, sentences, "first")
+	if !slices.Equal(ranked["fox"], sentences) {
+		t.Errorf("first ranking for fox = %q, want document order %q", ranked["fox"], sentences)
+	}
+}
+
+// Creates a temporary directory holding a plain file named "input", colliding with that output directory
+func collidingOutputBase(t *testing.T) string {
+	t.Helper()
+	base := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(base, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+func TestResolveOutputDirErrorMode(t *testing.T) {
+	base := collidingOutputBase(t)
+	if _, err := resolveOutputDir(base, "error", time.Now()); err == nil {
+		t.Error("expected an error for a file in the way of the output directory")
+	}
+
+	free := filepath.Join(t.TempDir(), "free")
+	if got, err := resolveOutputDir(free, "error", time.Now()); err != nil || got != free {
+		t.Errorf("resolveOutputDir(%q) = %q, %v; want the name unchanged", free, got, err)
+	}
+}
+
+func TestResolveOutputDirSuffixMode(t *testing.T) {
+	base := collidingOutputBase(t)
+	if err := os.WriteFile(base+"-1", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := resolveOutputDir(base, "suffix", time.Now()); err != nil || got != base+"-2" {
+		t.Errorf("resolveOutputDir = %q, %v; want %q", got, err, base+"-2")
+	}
+}
+
+func TestResolveOutputDirTimestampMode(t *testing.T) {
+	base := collidingOutputBase(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if got, err := resolveOutputDir(base, "timestamp", now); err != nil || got != base+"-20240102-030405" {
+		t.Errorf("resolveOutputDir = %q, %v; want %q", got, err, base+"-20240102-030405")
+	}
+}
+
+func TestDedupeExamplesGloballyWritesSharedExampleOnce(t *testing.T) {
+	shared := "The fox chased the dog into the field."
+	dictionary := map[string]WordCache{
+		"fox": {Definitions: []Definition{{PartOfSpeech: "noun", Definition: "A small wild animal of the dog family.", Examples: []string{shared}}}},
+		"dog": {Definitions: []Definition{{PartOfSpeech: "noun", Definition: "A domesticated animal kept as a pet.", Examples: []string{shared, "The dog slept."}}}},
+	}
+	for _, dedupe := range []bool{false, true} {
+		cfg := testConfig()
+		cfg.DedupeExamplesGlobally = dedupe
+		input := filepath.Join(t.TempDir(), "input.txt")
+		if err := os.WriteFile(input, []byte("The fox saw the dog."), 0644); err != nil {
+			t.Fatal(err)
+		}
+		deps := testDependencies(t, cfg)
+		deps.Provider = mapDictionaryProvider(dictionary)
+		if err := categorizeText(input, deps); err != nil {
+			t.Fatal(err)
+		}
+
+		want := 2
+		if dedupe {
+			want = 1
+		}
+		examples := readOutput(t, deps.OutputDir, "input_AllWords_es.txt")
+		if got := strings.Count(examples, shared); got != want {
+			t.Errorf("DedupeExamplesGlobally=%v: shared example written %d times, want %d:\n%s", dedupe, got, want, examples)
+		}
+	}
+}
+
+func TestFormatPronunciationLine(t *testing.T) {
+	cache := map[string]WordCache{
+		"fox":   {Phonetic: "/fɒks/"},
+		"dog":   {Phonetic: "dɒɡ"},
+		"field": {},
+	}
+	tests := []struct {
+		word     string
+		mark     bool
+		wantLine string
+		wantOK   bool
+	}{
+		{"fox", false, "Fox  /fɒks/", true},
+		{"fox", true, "Fox  /fɒks/", true},
+		{"dog", false, "Dog  /dɒɡ/", true},
+		{"field", false, "", false},
+		{"field", true, "Field  (no phonetic)", true},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.MarkMissingPhonetic = tt.mark
+		c := newClassifier(Dependencies{Config: cfg, Cache: cache})
+		line, ok := c.formatPronunciationLine(tt.word)
+		if line != tt.wantLine || ok != tt.wantOK {
+			t.Errorf("formatPronunciationLine(%q) with MarkMissingPhonetic=%v = %q, %v; want %q, %v", tt.word, tt.mark, line, ok, tt.wantLine, tt.wantOK)
+		}
+	}
+}
+
+func TestSampleSentencesIsReproducible(t *testing.T) {
+	var sentences []string
+	for i := 0; i < 40; i++ {
+		sentences = append(sentences, fmt.Sprintf("Sentence %02d.", i))
+	}
+
+	sample := sampleSentences(sentences, 25, 7)
+	if len(sample) != 10 {
+		t.Errorf("sampled %d of 40 sentences at 25%%, want 10", len(sample))
+	}
+	if !slices.IsSorted(sample) {
+		t.Errorf("sample is not in document order: %q", sample)
+	}
+	if again := sampleSentences(sentences, 25, 7); !slices.Equal(again, sample) {
+		t.Errorf("same seed gave a different sample:\n%q\n%q", sample, again)
+	}
+	if other := sampleSentences(sentences, 25, 8); slices.Equal(other, sample) {
+		t.Errorf("different seeds gave the same sample: %q", sample)
+	}
+	if got := sampleSentences(sentences[:3], 1, 7); len(got) != 1 {
+		t.Errorf("sampled %d sentences at 1%% of 3, want at least 1", len(got))
+	}
+}
+
+func TestGroupByFirstLetter(t *testing.T) {
+	groups := groupByFirstLetter([]string{"dog", "Apple", "ant", "42nd", "élan", "Dart", "'tis"})
+
+	want := map[string][]string{
+		"A": {"ant", "Apple"},
+		"D": {"Dart", "dog"},
+		"É": {"élan"},
+		"#": {"'tis", "42nd"},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("groupByFirstLetter = %q, want %q", groups, want)
+	}
+}
+
+func TestCapitalizeSentence(t *testing.T) {
+	tests := map[string]string{
+		"the fox ran.":             "The fox ran.",
+		"\"the fox ran,\" he said": "\"The fox ran,\" he said",
+		"(see below)":              "(See below)",
+		"42 foxes ran.":            "42 foxes ran.",
+		"iPhone sales rose.":       "iPhone sales rose.",
+		"The fox ran.":             "The fox ran.",
+		"":                         "",
+	}
+	for sentence, want := range tests {
+		if got := capitalizeSentence(sentence); got != want {
+			t.Errorf("capitalizeSentence(%q) = %q, want %q", sentence, got, want)
+		}
+	}
+}
+
+func TestFindLikelyProperNouns(t *testing.T) {
+	sentences := []string{
+		"Yesterday we visited Paris with Anna.",
+		"Paris was sunny, and I liked it.",
+		"Dogs ran in the park.",
+		"Some dogs barked.",
+		"Green fields were everywhere, and the green hills too.",
+		"The Green party met, and I saw the green field.",
+	}
+
+	want := map[string]bool{"paris": true, "anna": true}
+	if got := findLikelyProperNouns(sentences); !reflect.DeepEqual(got, want) {
+		t.Errorf("findLikelyProperNouns = %v, want %v", got, want)
+	}
+}
+
+func TestFilterSummaryReconcilesWithWordFamilies(t *testing.T) {
+	cfg := testConfig()
+	cfg.GroupWordFamilies = true
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("They ran home. The dog ran after the fox."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	deps := testDependencies(t, cfg)
+	deps.Out = &out
+	if err := categorizeText(input, deps); err != nil {
+		t.Fatal(err)
+	}
+
+	var raw, output, removed, known, unknown int
+	for _, line := range strings.Split(out.String(), "\n") {
+		var n int
+		switch {
+		case strings.HasPrefix(line, "Unique words in input:"):
+			fmt.Sscanf(line, "Unique words in input: %d, in output: %d", &raw, &output)
+		case strings.HasPrefix(line, "  Removed ("):
+			if _, err := fmt.Sscanf(line[strings.Index(line, ")")+1:], ": %d", &n); err != nil {
+				t.Fatalf("malformed summary line %q: %v", line, err)
+			}
+			removed += n
+		case strings.HasPrefix(line, "Known words:"):
+			fmt.Sscanf(line, "Known words: %d, Unknown words: %d", &known, &unknown)
+		}
+	}
+
+	if raw == 0 || raw != output+removed {
+		t.Errorf("raw unique words %d != output %d + removed %d:\n%s", raw, output, removed, out.String())
+	}
+	// Run stands in for "ran", so it counts towards the output alongside Dog and Fox
+	if output != known || known != 3 {
+		t.Errorf("output unique words %d, known words %d; want 3 each:\n%s", output, known, out.String())
+	}
+}
+
+func TestGlossaryTermsNeedNoLookups(t *testing.T) {
+	glossaryFile := filepath.Join(t.TempDir(), "glossary.csv")
+	glossaryCSV := "word,definition,partOfSpeech\nmeadow,A field of grass and wildflowers kept for hay.,noun\n"
+	if err := os.WriteFile(glossaryFile, []byte(glossaryCSV), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("The dog sleeps in the meadow."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deps := testDependencies(t, testConfig())
+	deps.Glossary = loadGlossaryFile(glossaryFile, deps.Logger)
+	var lookups []string
+	deps.Provider = lookupRecorder{provider: mapDictionaryProvider(loadTestDictionary(t)), words: &lookups}
+	if err := categorizeText(input, deps); err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Contains(lookups, "meadow") {
+		t.Error("glossary term was looked up in the dictionary")
+	}
+	if !slices.Contains(lookups, "dog") {
+		t.Error("ordinary words were not looked up")
+	}
+	want := "Meadow\n\tMeadow 1, noun: A field of grass and wildflowers kept for hay."
+	if explanations := readOutput(t, deps.OutputDir, "input_Nouns_ex.txt"); !strings.Contains(explanations, want) {
+		t.Errorf("_Nouns_ex.txt has no glossary definition %q:\n%s", want, explanations)
+	}
+}
+
+func TestFormatThesaurusLineGolden(t *testing.T) {
+	c := newClassifier(testDependencies(t, testConfig()))
+	c.Cache = loadTestDictionary(t)
+	c.Cache["bright"] = WordCache{
+		Synonyms: []string{"shiny"},
+		Definitions: []Definition{
+			{PartOfSpeech: "adjective", Definition: "Giving out a lot of light.", Synonyms: []string{"vivid", "shiny"}, Antonyms: []string{"dull"}},
+			{PartOfSpeech: "adjective", Definition: "Intelligent and quick-witted.", Synonyms: []string{"clever"}, Antonyms: []string{"dim", "dull"}},
+		},
+	}
+
+	var got strings.Builder
+	for _, word := range []string{"bright", "fox", "happy", "field", "unknown"} {
+		if line, ok := c.formatThesaurusLine(word); ok {
+			got.WriteString(line + "\n")
+		}
+	}
+
+	goldenPath := filepath.Join("testdata", "golden", "thesaurus.txt")
+	if *update {
+		if err := os.WriteFile(goldenPath, []byte(got.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("thesaurus differs from golden copy:\ngot:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
+
+func TestDiverseExamplesCoverEverySenseFirst(t *testing.T) {
+	cfg := testConfig()
+	cfg.DiverseExamples = true
+	cfg.MaxExampleSentences = 4
+	c := newClassifier(testDependencies(t, cfg))
+	c.Cache["bank"] = WordCache{Definitions: []Definition{
+		{PartOfSpeech: "noun", Definition: "An institution that keeps money.", Examples: []string{"money 1", "money 2", "money 3"}},
+		{PartOfSpeech: "noun", Definition: "The land beside a river.", Examples: []string{"river 1", "river 2"}},
+		{PartOfSpeech: "verb", Definition: "To tilt an aircraft in a turn.", Examples: []string{"tilt 1"}},
+	}}
+
+	want := "Bank\n\tMoney 1\n\tRiver 1\n\tTilt 1\n\tMoney 2"
+	for seed := int64(1); seed <= 5; seed++ {
+		if got := c.generateExampleSentencesContent("bank", nil, nil, rand.New(rand.NewSource(seed))); got != want {
+			t.Errorf("seed %d: examples = %q, want %q", seed, got, want)
+		}
+	}
+}
+
+func TestMergeExampleSourcesTagsAndCap(t *testing.T) {
+	cfg := testConfig()
+	cfg.UseSourceExamples = true
+	cfg.MergeExampleSources = true
+	cfg.MaxExampleSentences = 3
+	c := newClassifier(testDependencies(t, cfg))
+	c.Cache = loadTestDictionary(t)
+	source := []string{"the dog ran home.", "A dog slept.", "Every dog barks."}
+
+	got := c.generateExampleSentencesContent("dog", source, nil, rand.New(rand.NewSource(1)))
+	lines := strings.Split(got, "\n")
+	if len(lines) != 1+cfg.MaxExampleSentences {
+		t.Fatalf("got %d examples, want %d:\n%s", len(lines)-1, cfg.MaxExampleSentences, got)
+	}
+	if want := []string{"Dog", "\t[source] The dog ran home.", "\t[source] A dog slept."}; !slices.Equal(lines[:3], want) {
+		t.Errorf("source examples = %q, want %q", lines[:3], want)
+	}
+	dictionaryExamples := []string{"The dog barked at the postman.", "She walks her dog every morning.", "Reporters dogged the minister all week."}
+	if example, ok := strings.CutPrefix(lines[3], "\t[dict] "); !ok || !slices.Contains(dictionaryExamples, example) {
+		t.Errorf("dictionary example = %q, want a [dict] tagged example of dog", lines[3])
+	}
+}
+
+func TestCleanPhonetic(t *testing.T) {
+	tests := []struct {
+		phonetic string
+		want     string
+		wantOK   bool
+	}{
+		{"  /ˈhæpi/ /ˈhæp.i/ ", "/ˈhæpi/", true},
+		{"ˈhæpi, ˈhæp.i", "ˈhæpi", true},
+		{"[dɒɡ] [dɔɡ]", "[dɒɡ]", true},
+		{"/f&#618;&#720;ld/", "/fɪːld/", true},
+		{"", "", true},
+		{"<span>fɒks</span>", "<span>fɒks</span>", false},
+		{"/123/", "/123/", false},
+		{"//", "//", false},
+	}
+	for _, tt := range tests {
+		got, ok := cleanPhonetic(tt.phonetic)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("cleanPhonetic(%q) = %q, %v; want %q, %v", tt.phonetic, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestStrictPhoneticDropsUnusablePhonetics(t *testing.T) {
+	dictionary := map[string]WordCache{
+		"fox": {Phonetic: "/fɒks/ /fɑks/"},
+		"dog": {Phonetic: "<b>dog</b>"},
+	}
+	for _, strict := range []bool{false, true} {
+		cfg := testConfig()
+		cfg.StrictPhonetic = strict
+		deps := testDependencies(t, cfg)
+		deps.Provider = mapDictionaryProvider(dictionary)
+		c := newClassifier(deps)
+		for word := range dictionary {
+			if !c.queryDictionaryAPI(word) {
+				t.Fatalf("%q not found", word)
+			}
+		}
+
+		if got := c.Cache["fox"].Phonetic; got != "/fɒks/" {
+			t.Errorf("StrictPhonetic=%v: fox phonetic = %q, want %q", strict, got, "/fɒks/")
+		}
+		wantDog := "<b>dog</b>"
+		if strict {
+			wantDog = ""
+		}
+		if got := c.Cache["dog"].Phonetic; got != wantDog {
+			t.Errorf("StrictPhonetic=%v: dog phonetic = %q, want %q", strict, got, wantDog)
+		}
+	}
+}
+
+func TestExampleCountByLevelGivesHarderWordsMoreExamples(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxExampleSentences = 2
+	cfg.ExampleCountByLevel = map[string]int{"a1": 1, "C1": 4}
+	deps := testDependencies(t, cfg)
+	deps.WordLevels = map[string]string{"easy": "A1", "arcane": "C1"}
+	c := newClassifier(deps)
+	examples := []string{"One.", "Two.", "Three.", "Four.", "Five."}
+	for _, word := range []string{"easy", "arcane", "plain"} {
+		c.Cache[word] = WordCache{Definitions: []Definition{{PartOfSpeech: "adjective", Definition: "A test word.", Examples: examples}}}
+	}
+
+	wantCounts := map[string]int{"easy": 1, "arcane": 4, "plain": 2}
+	for word, want := range wantCounts {
+		content := c.generateExampleSentencesContent(word, nil, nil, rand.New(rand.NewSource(1)))
+		if got := strings.Count(content, "\n\t"); got != want {
+			t.Errorf("%s got %d examples, want %d:\n%s", word, got, want, content)
+		}
+	}
+}
+
+func TestPrimaryDefinitionSkipsLowQualityDefinitions(t *testing.T) {
+	cfg := testConfig()
+	cfg.FilterLowQualityDefinitions = true
+	c := newClassifier(testDependencies(t, cfg))
+	c.Cache["dogs"] = WordCache{Definitions: []Definition{
+		{PartOfSpeech: "noun", Definition: "Plural of dog."},
+		{PartOfSpeech: "noun", Definition: "Feet, especially when sore or tired."},
+	}}
+	// Every definition is low quality, so the longer non-cross-reference one is kept
+	c.Cache["foxes"] = WordCache{Definitions: []Definition{
+		{PartOfSpeech: "noun", Definition: "Plural of fox."},
+		{PartOfSpeech: "verb", Definition: "Tricks."},
+		{PartOfSpeech: "verb", Definition: "Baffles someone."},
+	}}
+
+	glossary := c.generateGlossaryContent(map[string][]string{"dogs": {"Nouns"}, "foxes": {"Verbs"}})
+	wantGlossary := "Dogs (noun): Feet, especially when sore or tired.\nFoxes (verb): Baffles someone.\n"
+	if glossary != wantGlossary {
+		t.Errorf("glossary = %q, want %q", glossary, wantGlossary)
+	}
+
+	cloze := c.generateClozeContent([]string{"dogs", "foxes"}, []string{"My dogs ache.", "The puzzle foxes him."}, nil)
+	wantCloze := "My {{c1::dogs}} ache.\tDogs (noun): Feet, especially when sore or tired.\n" +
+		"The puzzle {{c1::foxes}} him.\tFoxes (verb): Baffles someone.\n"
+	if cloze != wantCloze {
+		t.Errorf("cloze = %q, want %q", cloze, wantCloze)
+	}
+}
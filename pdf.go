@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	// gofpdf's repository is archived, but the library is pure Go with no dependencies
+	// outside the standard library, and the parts used here (core fonts, MultiCell, page
+	// footers) are stable. github.com/go-pdf/fpdf is the maintained fork with the same API,
+	// so moving to it is an import path change if a fix from upstream is ever needed.
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Writes a paginated PDF study sheet with each known word's phonetic, definitions and examples.
+// The core PDF fonts only cover cp1252, so characters outside it (such as most IPA symbols)
+// are replaced by the translator.
+func (c *classifier) writeStudySheetPDF(path string, title string, words []string) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(title, true)
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 6, fmt.Sprintf("Page %d/{nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, tr(title), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	for _, word := range words {
+		cachedData, exists := c.Cache[wordKey(word)]
+		if !exists || len(cachedData.Definitions) == 0 {
+			continue
+		}
+
+		heading := capitalizePhrase(word)
+		if c.Config.IncludePhonetic && cachedData.Phonetic != "" {
+			heading += "  " + cachedData.Phonetic
+		}
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.MultiCell(0, 6, tr(heading), "", "L", false)
+
+		lowQuality := c.lowQualityDefinitions(cachedData.Definitions)
+		for i, def := range cachedData.Definitions {
+			if c.Config.FilterNoExample && len(def.Examples) == 0 {
+				continue
+			}
+			if lowQuality[i] {
+				continue
+			}
+			pdf.SetFont("Helvetica", "", 10)
+			pdf.SetX(20)
+			pdf.MultiCell(0, 5, tr(fmt.Sprintf("%d. (%s) %s", i+1, def.PartOfSpeech, def.Definition)), "", "L", false)
+
+			pdf.SetFont("Helvetica", "I", 10)
+			for _, example := range def.Examples {
+				pdf.SetX(26)
+				pdf.MultiCell(0, 5, tr(capitalizeSentence(example)), "", "L", false)
+			}
+		}
+		pdf.Ln(3)
+	}
+
+	return pdf.OutputFileAndClose(path)
+}
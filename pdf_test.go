@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteStudySheetPDF(t *testing.T) {
+	cfg := testConfig()
+	cfg.IncludePhonetic = true
+	c := newClassifier(Dependencies{Config: cfg, Cache: loadTestDictionary(t)})
+	path := filepath.Join(t.TempDir(), "sample.pdf")
+
+	if err := c.writeStudySheetPDF(path, "Sample", []string{"fox", "dog", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Errorf("study sheet does not start with a PDF header: %q", data[:min(len(data), 16)])
+	}
+	if !bytes.Contains(data, []byte("%%EOF")) {
+		t.Error("study sheet is missing the PDF trailer")
+	}
+}
+
+// Returns the decompressed content streams of a PDF written by gofpdf
+func pdfContent(t *testing.T, data []byte) string {
+	t.Helper()
+	var content strings.Builder
+	for {
+		start := bytes.Index(data, []byte("stream\n"))
+		if start < 0 {
+			return content.String()
+		}
+		data = data[start+len("stream\n"):]
+		end := bytes.Index(data, []byte("\nendstream"))
+		if end < 0 {
+			t.Fatal("unterminated PDF stream")
+		}
+		if reader, err := zlib.NewReader(bytes.NewReader(data[:end])); err == nil {
+			decompressed, _ := io.ReadAll(reader)
+			content.Write(decompressed)
+		}
+		data = data[end+len("\nendstream"):]
+	}
+}
+
+func TestWriteStudySheetPDFSkipsLowQualityDefinitions(t *testing.T) {
+	cfg := testConfig()
+	cfg.FilterLowQualityDefinitions = true
+	c := newClassifier(Dependencies{Config: cfg, Cache: map[string]WordCache{
+		"dogs": {Definitions: []Definition{
+			{PartOfSpeech: "noun", Definition: "Plural of dog."},
+			{PartOfSpeech: "noun", Definition: "Feet, especially when sore or tired."},
+		}},
+	}})
+	path := filepath.Join(t.TempDir(), "sample.pdf")
+
+	if err := c.writeStudySheetPDF(path, "Sample", []string{"dogs"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := pdfContent(t, data)
+	if strings.Contains(content, "Plural of dog") {
+		t.Error("study sheet lists a low-quality definition")
+	}
+	if !strings.Contains(content, "Feet, especially when sore or tired.") {
+		t.Errorf("study sheet is missing the kept definition:\n%s", content)
+	}
+}
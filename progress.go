@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+)
+
+// How often buffered processed.jsonl lines are flushed, so monitors see recent progress
+// without a write per word
+const (
+	processedFlushLines    = 50
+	processedFlushInterval = time.Second
+)
+
+// One line of processed.jsonl
+type processedRecord struct {
+	Word      string    `json:"word"`
+	Category  string    `json:"category"`
+	Outcome   string    `json:"outcome"` // "known", "unknown" or "deferred"
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Appends a JSON line per completed word to processed.jsonl. A nil index records
+// nothing, so callers don't need to check whether the option is enabled. The file is
+// opened for appending rather than replaced, so a monitor tailing it never sees it
+// truncated and a rerun into the same output directory keeps the earlier records,
+// which the timestamps tell apart.
+type processedIndex struct {
+	writer    *outputWriter
+	now       func() time.Time
+	pending   int
+	lastFlush time.Time
+}
+
+// Returns nil when WriteProcessedIndex is disabled
+func (c *classifier) newProcessedIndex(outputDir string) (*processedIndex, error) {
+	if !c.Config.WriteProcessedIndex {
+		return nil, nil
+	}
+	writer, err := c.newAppendingOutputWriter(filepath.Join(outputDir, "processed.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	return &processedIndex{writer: writer, now: c.Now, lastFlush: c.Now()}, nil
+}
+
+func (pi *processedIndex) Record(word string, category string, outcome string) error {
+	if pi == nil {
+		return nil
+	}
+	now := pi.now()
+	line, err := json.Marshal(processedRecord{Word: word, Category: category, Outcome: outcome, Timestamp: now})
+	if err != nil {
+		return err
+	}
+	if err := pi.writer.WriteString(string(line) + "\n"); err != nil {
+		return err
+	}
+
+	pi.pending++
+	if pi.pending >= processedFlushLines || now.Sub(pi.lastFlush) >= processedFlushInterval {
+		pi.pending = 0
+		pi.lastFlush = now
+		return pi.writer.Flush()
+	}
+	return nil
+}
+
+func (pi *processedIndex) Close() error {
+	if pi == nil {
+		return nil
+	}
+	return pi.writer.Close()
+}
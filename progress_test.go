@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessedIndexRecordsEveryWordOnce(t *testing.T) {
+	cfg := testConfig()
+	cfg.WriteProcessedIndex = true
+	cfg.MaxOutputWords = 3
+	deps := testDependencies(t, cfg)
+	sample := filepath.Join("testdata", "sample.txt")
+	if err := categorizeText(sample, deps); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(deps.OutputDir, "processed.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcomes := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields()
+		var record processedRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("malformed line %q: %v", line, err)
+		}
+		if record.Category == "" || !record.Timestamp.Equal(deps.Now()) {
+			t.Errorf("incomplete record %q", line)
+		}
+		if _, seen := outcomes[record.Word]; seen {
+			t.Errorf("%q recorded more than once", record.Word)
+		}
+		outcomes[record.Word] = record.Outcome
+	}
+	for word, want := range map[string]string{"fox": "known", "dog": "known", "the": "unknown", "green": "deferred"} {
+		if got := outcomes[word]; got != want {
+			t.Errorf("%q recorded as %q, want %q", word, got, want)
+		}
+	}
+
+	// A second run into the same directory adds its records after the first run's
+	if err := categorizeText(sample, deps); err != nil {
+		t.Fatal(err)
+	}
+	again, err := os.ReadFile(filepath.Join(deps.OutputDir, "processed.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, append(data, data...)) {
+		t.Errorf("rerun did not append to processed.jsonl:\n%s", again)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Returned by a DictionaryProvider when the dictionary has no entry for a word
+var errWordNotFound = errors.New("word not found")
+
+// Returned when a dictionary response body is larger than MaxResponseBytes
+var errResponseTooLarge = errors.New("dictionary response too large")
+
+// Source of dictionary entries. Implementations return errWordNotFound when the word
+// has no entry, and any other error when the lookup itself failed.
+type DictionaryProvider interface {
+	Lookup(word string, language string) (WordCache, error)
+}
+
+// Serves entries from a fixed map without any network access
+type mapDictionaryProvider map[string]WordCache
+
+func (p mapDictionaryProvider) Lookup(word string, language string) (WordCache, error) {
+	entry, ok := p[wordKey(word)]
+	if !ok {
+		return WordCache{}, errWordNotFound
+	}
+	return entry, nil
+}
+
+// Looks words up in a Free Dictionary API compatible HTTP service
+type httpDictionaryProvider struct {
+	client           *http.Client
+	urlTemplate      string // Takes the language code and the word
+	maxResponseBytes int64  // 0 means unlimited
+}
+
+func newHTTPDictionaryProvider(queryConfig QueryConfig, proxyConfig ProxyConfig) *httpDictionaryProvider {
+	return &httpDictionaryProvider{
+		client:           createHTTPClient(queryConfig, proxyConfig),
+		urlTemplate:      queryConfig.DictionaryAPIURL,
+		maxResponseBytes: queryConfig.MaxResponseBytes,
+	}
+}
+
+func (p *httpDictionaryProvider) Lookup(word string, language string) (WordCache, error) {
+	apiURL := fmt.Sprintf(p.urlTemplate, url.PathEscape(language), url.PathEscape(word))
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return WordCache{}, err
+	}
+
+	req.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return WordCache{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return WordCache{}, errWordNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return WordCache{}, &statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	// Read one byte past the limit to tell a body of exactly the limit from a larger one
+	var body io.Reader = resp.Body
+	if p.maxResponseBytes > 0 {
+		body = io.LimitReader(resp.Body, p.maxResponseBytes+1)
+	}
+	bodyBytes, err := io.ReadAll(body)
+	if err != nil {
+		return WordCache{}, err
+	}
+	if p.maxResponseBytes > 0 && int64(len(bodyBytes)) > p.maxResponseBytes {
+		return WordCache{}, fmt.Errorf("%w: over %d bytes", errResponseTooLarge, p.maxResponseBytes)
+	}
+	return parseDictionaryResponse(bodyBytes)
+}
+
+// Converts a Free Dictionary API response body into a cache entry
+func parseDictionaryResponse(bodyBytes []byte) (WordCache, error) {
+	var result []map[string]interface{}
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return WordCache{}, fmt.Errorf("malformed dictionary response: %v", err)
+	}
+	if len(result) == 0 {
+		return WordCache{}, errWordNotFound
+	}
+
+	// Process API response into cache structure
+	cachedData := WordCache{
+		Definitions: []Definition{},
+		Phonetic:    "",
+		Origin:      "",
+		Synonyms:    []string{},
+		Antonyms:    []string{},
+	}
+
+	// Extract phonetic if available
+	if phonetic, ok := result[0]["phonetic"].(string); ok {
+		cachedData.Phonetic = phonetic
+	}
+
+	// Extract phonetics
+	if phonetics, ok := result[0]["phonetics"].([]interface{}); ok && cachedData.Phonetic == "" {
+		for _, p := range phonetics {
+			if phoneticMap, ok := p.(map[string]interface{}); ok {
+				if text, ok := phoneticMap["text"].(string); ok && text != "" {
+					cachedData.Phonetic = text
+					break
+				}
+			}
+		}
+	}
+
+	// Extract the first available pronunciation audio URL
+	if phonetics, ok := result[0]["phonetics"].([]interface{}); ok {
+		for _, p := range phonetics {
+			if phoneticMap, ok := p.(map[string]interface{}); ok {
+				if audio, ok := phoneticMap["audio"].(string); ok && audio != "" {
+					cachedData.Audio = audio
+					break
+				}
+			}
+		}
+	}
+
+	// Extract origin directly from the top level
+	if originStr, ok := result[0]["origin"].(string); ok {
+		cachedData.Origin = originStr
+	}
+
+	// Extract meanings, definitions, synonyms, antonyms
+	if meanings, ok := result[0]["meanings"].([]interface{}); ok {
+		for _, m := range meanings {
+			if meaningMap, ok := m.(map[string]interface{}); ok {
+				partOfSpeech := ""
+				if pos, ok := meaningMap["partOfSpeech"].(string); ok {
+					partOfSpeech = pos
+				}
+
+				// Extract definitions
+				if definitions, ok := meaningMap["definitions"].([]interface{}); ok {
+					for _, d := range definitions {
+						defMap, ok := d.(map[string]interface{})
+						if !ok {
+							continue
+						}
+
+						def := Definition{
+							PartOfSpeech: partOfSpeech,
+							Definition:   "",
+							Examples:     []string{},
+							Synonyms:     []string{},
+							Antonyms:     []string{},
+						}
+
+						if defStr, ok := defMap["definition"].(string); ok {
+							def.Definition = defStr
+						}
+
+						if exampleStr, ok := defMap["example"].(string); ok && exampleStr != "" {
+							def.Examples = append(def.Examples, exampleStr)
+						}
+
+						// Some senses carry several examples in an "examples" array
+						if examples, ok := defMap["examples"].([]interface{}); ok {
+							for _, ex := range examples {
+								if exStr, ok := ex.(string); ok && exStr != "" {
+									def.Examples = append(def.Examples, exStr)
+								}
+							}
+						}
+
+						// Extract synonyms and antonyms
+						if syns, ok := defMap["synonyms"].([]interface{}); ok {
+							for _, syn := range syns {
+								if synStr, ok := syn.(string); ok {
+									def.Synonyms = append(def.Synonyms, synStr)
+									cachedData.Synonyms = append(cachedData.Synonyms, synStr)
+								}
+							}
+						}
+
+						if ants, ok := defMap["antonyms"].([]interface{}); ok {
+							for _, ant := range ants {
+								if antStr, ok := ant.(string); ok {
+									def.Antonyms = append(def.Antonyms, antStr)
+									cachedData.Antonyms = append(cachedData.Antonyms, antStr)
+								}
+							}
+						}
+
+						cachedData.Definitions = append(cachedData.Definitions, def)
+					}
+				}
+			}
+		}
+	}
+
+	if len(cachedData.Definitions) == 0 {
+		return WordCache{}, errWordNotFound
+	}
+	return cachedData, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDictionaryResponseKeepsEveryExample(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("testdata", "api_two_examples.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := parseDictionaryResponse(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entry.Definitions) != 1 {
+		t.Fatalf("got %d definitions, want 1", len(entry.Definitions))
+	}
+	wantExamples := []string{"The bright sun rose over the hills.", "She wore a bright yellow coat."}
+	if got := entry.Definitions[0].Examples; strings.Join(got, "|") != strings.Join(wantExamples, "|") {
+		t.Fatalf("examples = %q, want %q", got, wantExamples)
+	}
+
+	c := newClassifier(testDependencies(t, testConfig()))
+	c.Cache["bright"] = entry
+
+	wantDetails := "Bright\n" +
+		"\tBright 1, adjective: Giving off or reflecting a lot of light.\n" +
+		"\t\tBright 1 Example: The bright sun rose over the hills.\n" +
+		"\t\tBright 1 Example: She wore a bright yellow coat."
+	if got := c.fetchWordDetails("bright", ""); got != wantDetails {
+		t.Errorf("fetchWordDetails = %q, want %q", got, wantDetails)
+	}
+
+	wantSentences := "Bright\n" +
+		"\tThe bright sun rose over the hills.\n" +
+		"\tShe wore a bright yellow coat."
+	if got := c.generateExampleSentencesContent("bright", nil, nil, c.Rand); got != wantSentences {
+		t.Errorf("generateExampleSentencesContent = %q, want %q", got, wantSentences)
+	}
+}
+
+func TestHTTPDictionaryProviderEnforcesMaxResponseBytes(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("testdata", "api_two_examples.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/en/bright":
+			w.Write(body)
+		case "/en/huge":
+			// Valid JSON, padded far past the limit
+			w.Write(body)
+			w.Write([]byte(strings.Repeat(" ", 1<<20)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	queryConfig := QueryConfig{
+		DictionaryAPIURL:   server.URL + "/%s/%s",
+		DictionaryLanguage: "en",
+		MaxResponseBytes:   int64(len(body)) + 100,
+	}
+	provider := newHTTPDictionaryProvider(queryConfig, ProxyConfig{})
+
+	if _, err := provider.Lookup("bright", "en"); err != nil {
+		t.Errorf("response under the limit: %v", err)
+	}
+	if _, err := provider.Lookup("huge", "en"); !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("oversized response: err = %v, want errResponseTooLarge", err)
+	}
+
+	deps := testDependencies(t, testConfig())
+	deps.QueryConfig = queryConfig
+	deps.Provider = provider
+	c := newClassifier(deps)
+	if got := c.fetchWordDetails("huge", ""); got != "" {
+		t.Errorf("fetchWordDetails(huge) = %q, want nothing", got)
+	}
+	if _, ok := c.Cache["huge"]; ok {
+		t.Error("oversized response was cached")
+	}
+	if got := c.fetchWordDetails("bright", ""); !strings.HasPrefix(got, "Bright\n") {
+		t.Errorf("fetchWordDetails(bright) = %q, want its details", got)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Common irregular inflections by category, keyed by surface form. A form is only
+// lemmatized in its own category, so the noun "saw" or the adjective "left" stay as they are.
+var irregularLemmas = map[string]map[string]string{
+	"Verbs": {
+		"ran": "run", "went": "go", "gone": "go", "was": "be", "were": "be", "been": "be",
+		"is": "be", "are": "be", "am": "be", "had": "have", "has": "have", "did": "do",
+		"done": "do", "does": "do", "made": "make", "said": "say", "took": "take",
+		"taken": "take", "came": "come", "saw": "see", "seen": "see", "knew": "know",
+		"known": "know", "got": "get", "gotten": "get", "gave": "give", "given": "give",
+		"found": "find", "thought": "think", "told": "tell", "became": "become",
+		"left": "leave", "felt": "feel", "brought": "bring", "began": "begin",
+		"begun": "begin", "kept": "keep", "held": "hold", "wrote": "write",
+		"written": "write", "stood": "stand", "heard": "hear", "meant": "mean",
+		"met": "meet", "paid": "pay", "sat": "sit", "spoke": "speak", "spoken": "speak",
+		"led": "lead", "grew": "grow", "grown": "grow", "lost": "lose", "fell": "fall",
+		"fallen": "fall", "sent": "send", "built": "build", "understood": "understand",
+		"drew": "draw", "drawn": "draw", "broke": "break", "broken": "break",
+		"spent": "spend", "rose": "rise", "risen": "rise", "drove": "drive",
+		"driven": "drive", "bought": "buy", "wore": "wear", "worn": "wear",
+		"chose": "choose", "chosen": "choose", "ate": "eat", "eaten": "eat",
+	},
+	"Nouns": {
+		"children": "child", "men": "man", "women": "woman", "people": "person",
+		"feet": "foot", "teeth": "tooth", "mice": "mouse", "geese": "goose",
+	},
+	"Adjectives": {
+		"better": "good", "best": "good", "worse": "bad", "worst": "bad",
+	},
+	"Adverbs": {
+		"better": "well", "best": "well", "worse": "badly", "worst": "badly",
+	},
+}
+
+// Regular lemma candidates for an inflected word of the given category, most likely first.
+// The "+e" stem comes before the bare one so "used" and "hoped" find "use" and "hope"
+// before "us" and "hop".
+func lemmaCandidates(word string, category string) []string {
+	var candidates []string
+	strip := func(suffix string, undoDoubling bool, replacements ...string) {
+		if !strings.HasSuffix(word, suffix) || len(word) <= len(suffix)+1 {
+			return
+		}
+		stem := strings.TrimSuffix(word, suffix)
+		for _, replacement := range replacements {
+			candidates = append(candidates, stem+replacement)
+		}
+		// Undo consonant doubling, as in "running" or "bigger", when the stem ends in one
+		if n := len(stem); undoDoubling && n >= 2 && stem[n-1] == stem[n-2] && !strings.ContainsRune("aeiouls", rune(stem[n-1])) {
+			candidates = append(candidates, stem[:n-1])
+		}
+	}
+
+	switch category {
+	case "Nouns":
+		strip("ies", false, "y")
+		strip("es", false, "e", "")
+		strip("s", false, "")
+	case "Verbs":
+		strip("ies", false, "y")
+		strip("es", false, "e", "")
+		strip("s", false, "")
+		strip("ied", false, "y")
+		strip("ed", true, "e", "")
+		strip("ing", true, "e", "")
+	case "Adjectives", "Adverbs":
+		strip("ier", false, "y")
+		strip("iest", false, "y")
+		strip("er", true, "e", "")
+		strip("est", true, "e", "")
+	}
+	return candidates
+}
+
+// Returns the lemma of word as used in category. Regular candidates are only accepted
+// when they appear in the input (observed), so a word whose lemma can't be confirmed is
+// left as it is. The word cache isn't consulted: it holds every word looked up in any
+// earlier run, so it would confirm "bit" as the lemma of "bitter" or "us" as that of "used".
+func lemmatize(word string, category string, observed map[string]int) string {
+	if lemma, ok := irregularLemmas[category][strings.ToLower(word)]; ok {
+		return lemma
+	}
+	for _, candidate := range lemmaCandidates(word, category) {
+		if _, ok := observed[candidate]; ok {
+			return candidate
+		}
+	}
+	return word
+}
+
+// Maps each counted word to its lemma, lemmatizing it by the category it was most often
+// tagged with. Words that are their own lemma are left out.
+func findWordLemmas(wordCategoryCounts map[string]map[string]int, observed map[string]int) map[string]string {
+	lemmas := make(map[string]string)
+	for word, categoryCounts := range wordCategoryCounts {
+		var category string
+		for _, candidate := range sortAlphabetically(mapKeys(categoryCounts)) {
+			if category == "" || categoryCounts[candidate] > categoryCounts[category] {
+				category = candidate
+			}
+		}
+		if lemma := lemmatize(word, category, observed); lemma != word {
+			lemmas[word] = lemma
+		}
+	}
+	return lemmas
+}
+
+func mapKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Returns the inflected forms observed for each lemma, most frequent first
+func groupWordFamilies(counts map[string]int, lemmas map[string]string) map[string][]string {
+	families := make(map[string][]string)
+	for word, lemma := range lemmas {
+		if counts[word] > 0 {
+			families[lemma] = append(families[lemma], word)
+		}
+	}
+	for lemma, forms := range families {
+		sort.Slice(forms, func(i, j int) bool {
+			if counts[forms[i]] != counts[forms[j]] {
+				return counts[forms[i]] > counts[forms[j]]
+			}
+			return forms[i] < forms[j]
+		})
+		families[lemma] = forms
+	}
+	return families
+}
+
+// Formats the "Forms:" line listed beneath a family's headword, with the combined
+// frequency of the lemma and its forms
+func formatFamilyLine(forms []string, combinedCount int) string {
+	return fmt.Sprintf("\tForms: %s (%d total)", strings.Join(forms, ", "), combinedCount)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLemmatizeIrregularFormsByCategory(t *testing.T) {
+	tests := []struct {
+		word     string
+		category string
+		want     string
+	}{
+		{word: "saw", category: "Verbs", want: "see"},
+		{word: "saw", category: "Nouns", want: "saw"},
+		{word: "left", category: "Verbs", want: "leave"},
+		{word: "left", category: "Adjectives", want: "left"},
+		{word: "children", category: "Nouns", want: "child"},
+		{word: "children", category: "Verbs", want: "children"},
+		{word: "better", category: "Adjectives", want: "good"},
+		{word: "better", category: "Adverbs", want: "well"},
+		{word: "better", category: "Verbs", want: "better"},
+		{word: "Went", category: "Verbs", want: "go"},
+	}
+	for _, tt := range tests {
+		if got := lemmatize(tt.word, tt.category, nil); got != tt.want {
+			t.Errorf("lemmatize(%q, %q) = %q, want %q", tt.word, tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestLemmatizePrefersStemWithE(t *testing.T) {
+	observed := map[string]int{"us": 4, "use": 1, "hop": 2, "hope": 1, "add": 1, "ad": 1, "run": 1, "big": 1}
+
+	tests := []struct {
+		word     string
+		category string
+		want     string
+	}{
+		{word: "used", category: "Verbs", want: "use"},
+		{word: "uses", category: "Verbs", want: "use"},
+		{word: "uses", category: "Nouns", want: "use"},
+		{word: "hoped", category: "Verbs", want: "hope"},
+		{word: "hopped", category: "Verbs", want: "hop"},
+		{word: "adding", category: "Verbs", want: "add"},
+		{word: "running", category: "Verbs", want: "run"},
+		{word: "bigger", category: "Adjectives", want: "big"},
+	}
+	for _, tt := range tests {
+		if got := lemmatize(tt.word, tt.category, observed); got != tt.want {
+			t.Errorf("lemmatize(%q, %q) = %q, want %q", tt.word, tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestGroupWordFamiliesListsFormsUnderLemma(t *testing.T) {
+	cfg := testConfig()
+	cfg.GroupWordFamilies = true
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte("I like to run. She runs every day. He was running. They ran home."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deps := testDependencies(t, cfg)
+	if err := categorizeText(input, deps); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(deps.OutputDir, "input_AllWords.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "Run\n"); got != 1 {
+		t.Errorf("_AllWords.txt lists Run %d times, want 1:\n%s", got, data)
+	}
+	want := "Run\n\tForms: ran, running, runs (4 total)\n"
+	if !strings.Contains(string(data), want) {
+		t.Errorf("_AllWords.txt has no %q:\n%s", want, data)
+	}
+	for _, form := range []string{"Runs\n", "Running\n", "Ran\n"} {
+		if strings.Contains(string(data), form) {
+			t.Errorf("_AllWords.txt still lists %q separately:\n%s", form, data)
+		}
+	}
+}